@@ -2,9 +2,11 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
@@ -15,8 +17,51 @@ import (
 const (
 	authCookieName    = "jwt"
 	apiRequestTimeout = 2 * time.Second
+	angelthumpAPIURL  = "https://api.angelthump.com"
 )
 
+var (
+	// errATUserNotFound is returned if angelthump doesn't know a user.
+	errATUserNotFound = errors.New("angelthump user not found")
+	errATNoToken      = errors.New("no angelthump admin token set")
+	// errATUnverified is reported for a set admin token, angelthump has no
+	// read-only endpoint to check it against.
+	errATUnverified = errors.New("token set, unverified")
+)
+
+// apiError is returned if a backend answers with an unexpected status code.
+type apiError struct {
+	StatusCode int
+	// Message is the error message the backend sent, if any.
+	Message string
+}
+
+func (e *apiError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("status code %d", e.StatusCode)
+	}
+	return fmt.Sprintf("status code %d: %s", e.StatusCode, e.Message)
+}
+
+// newAPIError builds an apiError from the backend's "message" or "error"
+// field, falling back to the raw body.
+func newAPIError(statusCode int, body []byte) *apiError {
+	var e struct {
+		Message string `json:"message"`
+		Error   string `json:"error"`
+	}
+	msg := strings.TrimSpace(string(body))
+	if err := json.Unmarshal(body, &e); err == nil {
+		switch {
+		case e.Message != "":
+			msg = e.Message
+		case e.Error != "":
+			msg = e.Error
+		}
+	}
+	return &apiError{StatusCode: statusCode, Message: msg}
+}
+
 type userInfo struct {
 	Username string `json:"username"`
 	IsAdmin  bool   `json:"is_admin"`
@@ -36,49 +81,63 @@ type streamData struct {
 	} `json:"stream_list"`
 }
 
-type errorResp struct {
-	Error string `json:"error"`
+// strimsClient talks to the strims backend api.
+type strimsClient struct {
+	baseURL    string
+	authCookie string
+	client     *http.Client
 }
 
-func (b *bot) initHeaders(req *http.Request) *http.Request {
-	c := fmt.Sprintf("%s=%s", authCookieName, b.authCookie)
-	req.Header.Set("Cookie", c)
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("X-Bot", "botnet")
-	return req
+func newStrimsClient(baseURL, authCookie string) *strimsClient {
+	return &strimsClient{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		authCookie: authCookie,
+		client: &http.Client{
+			Timeout:   apiRequestTimeout,
+			Transport: metricsTransport{api: "strims", next: http.DefaultTransport},
+		},
+	}
 }
 
-// Send rename request to backend.
-func (b *bot) renameUser(oldName string, newName string) error {
-	jsonStr := []byte(fmt.Sprintf(`{"username":"%s"}`, newName))
-	path := fmt.Sprintf("%s/admin/profiles/%s/username", backendURL, oldName)
-	req, err := http.NewRequest("POST", path, bytes.NewBuffer(jsonStr))
+// do sends a request to path and decodes a successful json response into v,
+// unless v is nil.
+func (c *strimsClient) do(ctx context.Context, method, path string, body io.Reader, v interface{}) error {
+	req, err := http.NewRequest(method, c.baseURL+path, body)
 	if err != nil {
 		return err
 	}
-	req = b.initHeaders(req)
+	req = req.WithContext(ctx)
+	req.Header.Set("Cookie", fmt.Sprintf("%s=%s", authCookieName, c.authCookie))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Bot", "botnet")
 
-	client := &http.Client{Timeout: apiRequestTimeout}
-	resp, err := client.Do(req)
+	resp, err := c.client.Do(req)
 	if err != nil {
 		return err
 	}
-	body, err := ioutil.ReadAll(resp.Body)
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		return err
 	}
-	if resp.StatusCode != 200 {
-		unmarshalled := map[string]interface{}{}
-		if err := json.Unmarshal(body, &unmarshalled); err != nil {
-			return fmt.Errorf("failed to unmarshal response: %v", err)
-		}
-		msg, ok := unmarshalled["message"].(string)
-		if !ok {
-			return fmt.Errorf("status code %d, %s", resp.StatusCode, body)
-		}
-		return fmt.Errorf("%s", msg)
+	if resp.StatusCode != http.StatusOK {
+		return newAPIError(resp.StatusCode, data)
 	}
-	return nil
+	if v == nil {
+		return nil
+	}
+	return json.Unmarshal(data, v)
+}
+
+// Send rename request to backend.
+func (c *strimsClient) renameUser(ctx context.Context, oldName string, newName string) error {
+	body, err := json.Marshal(map[string]string{"username": newName})
+	if err != nil {
+		return err
+	}
+	path := fmt.Sprintf("/admin/profiles/%s/username", url.PathEscape(oldName))
+	return c.do(ctx, http.MethodPost, path, bytes.NewReader(body), nil)
 }
 
 // string because we don't want false default bools when marshaling
@@ -91,7 +150,7 @@ type streamModifier struct {
 
 // Modify stream attributes (nsfw/hidden/...)
 // identifier can be a stream_path (simple string) or "{service}/{channel}"
-func (b *bot) setStreamAttributes(identifier string, modifier streamModifier) error {
+func (c *strimsClient) setStreamAttributes(ctx context.Context, identifier string, modifier streamModifier) error {
 	jsonStr, err := json.Marshal(&modifier)
 	if err != nil {
 		return err
@@ -103,83 +162,25 @@ func (b *bot) setStreamAttributes(identifier string, modifier streamModifier) er
 	j = strings.ReplaceAll(j, "\"true\"", "true")
 	j = strings.ReplaceAll(j, "\"false\"", "false")
 
-	path := fmt.Sprintf("%s/admin/streams/%s", backendURL, identifier)
-	req, err := http.NewRequest("POST", path, bytes.NewBuffer([]byte(j)))
-	if err != nil {
-		return err
-	}
-	req = b.initHeaders(req)
-
-	client := &http.Client{Timeout: apiRequestTimeout}
-	resp, err := client.Do(req)
-	if err != nil {
-		return err
-	}
-
-	if resp.StatusCode == 200 {
-		return nil
-	}
-
-	// backend tells us a custom error message
-	var e errorResp
-	err = json.NewDecoder(resp.Body).Decode(&e)
-	if err != nil {
-		return err
-	}
-
-	return fmt.Errorf("error: %s", e.Error)
-}
-
-// build common get request...
-func (b *bot) buildGetRequest(path string) (*http.Request, error) {
-	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s%s", backendURL, path), nil)
-	if err != nil {
-		return nil, err
-	}
-	req = b.initHeaders(req)
-	return req, nil
+	return c.do(ctx, http.MethodPost, "/admin/streams/"+identifier, strings.NewReader(j), nil)
 }
 
 // get basic user info - to check if we are logged in and have correct rights
-func (b *bot) getProfileInfo() (userInfo, error) {
-	req, err := b.buildGetRequest("/profile")
-	if err != nil {
-		return userInfo{}, err
-	}
-	client := &http.Client{Timeout: apiRequestTimeout}
-	resp, err := client.Do(req)
-	if err != nil {
-		return userInfo{}, err
-	}
-
+func (c *strimsClient) getProfileInfo(ctx context.Context) (userInfo, error) {
 	var ui userInfo
-	err = json.NewDecoder(resp.Body).Decode(&ui)
-	if err != nil {
+	if err := c.do(ctx, http.MethodGet, "/profile", nil, &ui); err != nil {
 		return userInfo{}, err
 	}
-
 	return ui, nil
 }
 
 // Get list of current streams.
-func (b *bot) getStreamList() (streamData, error) {
-	// empty path (/api) holds stream data...
-	req, err := b.buildGetRequest("")
-	if err != nil {
-		return streamData{}, err
-	}
-	client := &http.Client{Timeout: apiRequestTimeout}
-	resp, err := client.Do(req)
-	if err != nil {
-		return streamData{}, err
-	}
-
+func (c *strimsClient) getStreamList(ctx context.Context) (streamData, error) {
 	var sd streamData
-	err = json.NewDecoder(resp.Body).Decode(&sd)
-	if err != nil {
+	// empty path (/api) holds stream data...
+	if err := c.do(ctx, http.MethodGet, "", nil, &sd); err != nil {
 		return streamData{}, err
 	}
-
 	return sd, nil
 }
 
@@ -199,40 +200,83 @@ type atData struct {
 	UpdatedAt time.Time `json:"updatedAt"`
 }
 
+// angelthumpClient talks to the angelthump api.
+type angelthumpClient struct {
+	baseURL    string
+	adminToken string
+	client     *http.Client
+}
+
+func newAngelthumpClient(baseURL, adminToken string) *angelthumpClient {
+	return &angelthumpClient{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		adminToken: adminToken,
+		client: &http.Client{
+			Timeout:   apiRequestTimeout * 2,
+			Transport: metricsTransport{api: "angelthump", next: http.DefaultTransport},
+		},
+	}
+}
+
 // interact with at backend
-func (b *bot) getATUserData(username string) (atData, error) {
-	path := fmt.Sprintf("https://api.angelthump.com/v3/streams/?username=%s", strings.ToLower(username))
+func (c *angelthumpClient) getATUserData(ctx context.Context, username string) (atData, error) {
+	path := fmt.Sprintf("%s/v3/streams/?username=%s", c.baseURL, url.QueryEscape(strings.ToLower(username)))
 	req, err := http.NewRequest(http.MethodGet, path, nil)
 	if err != nil {
 		return atData{}, err
 	}
-	req = b.initHeaders(req)
+	req = req.WithContext(ctx)
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("X-Bot", "botnet")
 
-	client := &http.Client{Timeout: apiRequestTimeout * 2}
-	resp, err := client.Do(req)
+	resp, err := c.client.Do(req)
 	if err != nil {
 		return atData{}, err
 	}
+	defer resp.Body.Close()
 
 	// don't check status code, the backend doesn't report it correctly.
 	// if user does not exist, content type is text/html.
 	if !strings.Contains(resp.Header.Get("content-type"), "application/json") {
-		return atData{}, errors.New("user not found - 404")
+		return atData{}, errATUserNotFound
 	}
 
 	var atds []atData
 	err = json.NewDecoder(resp.Body).Decode(&atds)
-	if err != nil || len(atds) == 0 {
+	if err != nil {
 		return atData{}, err
 	}
+	if len(atds) == 0 {
+		return atData{}, nil
+	}
 
 	return atds[0], nil
 }
 
+func (c *angelthumpClient) newAdminRequest(ctx context.Context, action string, form url.Values) (*http.Request, error) {
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/v2/admin/%s", c.baseURL, action),
+		strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("X-Bot", "botnet")
+	req.Header.Set("Authorization", fmt.Sprintf("key %s", c.adminToken))
+	return req, nil
+}
+
+// checkToken tells whether an admin token is set. Its validity is only known
+// once it's used, probing with an admin request would have side effects.
+func (c *angelthumpClient) checkToken() error {
+	if c.adminToken == "" {
+		return errATNoToken
+	}
+	return errATUnverified
+}
+
 // (un)ban AT user
-func (b *bot) banATuser(username string, reason string, ban bool) (string, error) {
+func (c *angelthumpClient) banATuser(ctx context.Context, username string, reason string, ban bool) (string, error) {
 	if reason == "" {
 		reason = "no reason provided"
 	}
@@ -242,22 +286,19 @@ func (b *bot) banATuser(username string, reason string, ban bool) (string, error
 		action = "ban"
 	}
 
-	path := fmt.Sprintf("https://api.angelthump.com/v2/admin/%s", action)
-
-	req, err := http.NewRequest(http.MethodPost, path, strings.NewReader(
-		fmt.Sprintf("username=%s&reason=%s", username, url.QueryEscape(reason))))
+	form := url.Values{}
+	form.Set("username", username)
+	form.Set("reason", reason)
+	req, err := c.newAdminRequest(ctx, action, form)
 	if err != nil {
 		return "", err
 	}
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	req.Header.Set("X-Bot", "botnet")
-	req.Header.Set("Authorization", fmt.Sprintf("key %s", atAdminToken))
 
-	client := &http.Client{Timeout: apiRequestTimeout * 2}
-	resp, err := client.Do(req)
+	resp, err := c.client.Do(req)
 	if err != nil {
 		return "", err
 	}
+	defer resp.Body.Close()
 
 	responseData, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
@@ -268,12 +309,18 @@ func (b *bot) banATuser(username string, reason string, ban bool) (string, error
 		Error    bool   `json:"error"`
 		ErrorMSG string `json:"errorMSG"`
 	}
-	if err := json.Unmarshal(responseData, resp); err != nil {
+	if err := json.Unmarshal(responseData, &erro); err != nil {
+		if resp.StatusCode != http.StatusOK {
+			return "", newAPIError(resp.StatusCode, responseData)
+		}
 		return "", err
 	}
 
 	if erro.Error {
-		return "", fmt.Errorf("failed to ban with: %q", erro.ErrorMSG)
+		return "", &apiError{StatusCode: resp.StatusCode, Message: erro.ErrorMSG}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", newAPIError(resp.StatusCode, responseData)
 	}
 
 	return "success", nil
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// fakeBackend serves a single canned response and records the last request.
+type fakeBackend struct {
+	status      int
+	contentType string
+	body        string
+
+	method string
+	path   string
+	query  string
+	header http.Header
+	sent   string
+}
+
+func (f *fakeBackend) start(t *testing.T) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		f.method, f.path, f.query, f.header, f.sent = r.Method, r.URL.Path, r.URL.RawQuery, r.Header, string(data)
+		if f.contentType != "" {
+			w.Header().Set("Content-Type", f.contentType)
+		}
+		w.WriteHeader(f.status)
+		fmt.Fprint(w, f.body)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestStrimsClient(t *testing.T) {
+	f := &fakeBackend{status: http.StatusOK, body: `{"username":"bot","is_admin":true}`}
+	c := newStrimsClient(f.start(t).URL+"/", "secret")
+	ctx := context.Background()
+
+	info, err := c.getProfileInfo(ctx)
+	if err != nil || info.Username != "bot" || !info.IsAdmin {
+		t.Fatalf("getProfileInfo() = %+v, %v", info, err)
+	}
+	if f.method != http.MethodGet || f.path != "/profile" {
+		t.Errorf("unexpected request %s %s", f.method, f.path)
+	}
+	if got := f.header.Get("Cookie"); got != "jwt=secret" {
+		t.Errorf("expected auth cookie, got %q", got)
+	}
+	if got := f.header.Get("X-Bot"); got != "botnet" {
+		t.Errorf("expected X-Bot header, got %q", got)
+	}
+
+	f.body = `{"stream_list":[{"channel":"abc","live":true,"viewers":3}]}`
+	sd, err := c.getStreamList(ctx)
+	if err != nil || len(sd.StreamList) != 1 || sd.StreamList[0].Viewers != 3 {
+		t.Fatalf("getStreamList() = %+v, %v", sd, err)
+	}
+
+	f.body = ""
+	if err := c.renameUser(ctx, "old name", "new"); err != nil {
+		t.Fatal(err)
+	}
+	if f.method != http.MethodPost || f.path != "/admin/profiles/old name/username" || f.sent != `{"username":"new"}` {
+		t.Errorf("unexpected rename request %s %s %s", f.method, f.path, f.sent)
+	}
+
+	if err := c.setStreamAttributes(ctx, "twitch/abc", streamModifier{Nsfw: "true", Hidden: "false"}); err != nil {
+		t.Fatal(err)
+	}
+	if f.path != "/admin/streams/twitch/abc" || f.sent != `{"nsfw":true,"hidden":false}` {
+		t.Errorf("unexpected modify request %s %s", f.path, f.sent)
+	}
+}
+
+func TestStrimsClientErrors(t *testing.T) {
+	tests := []struct {
+		body string
+		want string
+	}{
+		{`{"message":"no such user"}`, "status code 404: no such user"},
+		{`{"error":"not allowed"}`, "status code 404: not allowed"},
+		{"page not found\n", "status code 404: page not found"},
+		{"", "status code 404"},
+	}
+	for _, tt := range tests {
+		f := &fakeBackend{status: http.StatusNotFound, body: tt.body}
+		c := newStrimsClient(f.start(t).URL, "")
+
+		err := c.renameUser(context.Background(), "a", "b")
+		var apiErr *apiError
+		if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound {
+			t.Errorf("body %q: expected an apiError with status 404, got %v", tt.body, err)
+			continue
+		}
+		if err.Error() != tt.want {
+			t.Errorf("body %q: got %q, want %q", tt.body, err, tt.want)
+		}
+	}
+}
+
+func TestAngelthumpUserData(t *testing.T) {
+	f := &fakeBackend{
+		status:      http.StatusOK,
+		contentType: "application/json; charset=utf-8",
+		body:        `[{"viewer_count":5,"user":{"username":"abc","nsfw":true}}]`,
+	}
+	c := newAngelthumpClient(f.start(t).URL, "token")
+	ctx := context.Background()
+
+	atd, err := c.getATUserData(ctx, "ABC")
+	if err != nil || atd.ViewerCount != 5 || atd.User.Username != "abc" || !atd.User.Nsfw {
+		t.Fatalf("getATUserData() = %+v, %v", atd, err)
+	}
+	if f.path != "/v3/streams/" || f.query != "username=abc" {
+		t.Errorf("unexpected request %s?%s", f.path, f.query)
+	}
+
+	f.body = "[]"
+	if atd, err := c.getATUserData(ctx, "abc"); err != nil || atd.User.Username != "" {
+		t.Errorf("expected empty data for an offline user, got %+v, %v", atd, err)
+	}
+
+	// unknown users are answered with an html page.
+	f.contentType, f.body = "text/html", "<html></html>"
+	if _, err := c.getATUserData(ctx, "nobody"); !errors.Is(err, errATUserNotFound) {
+		t.Errorf("expected errATUserNotFound, got %v", err)
+	}
+}
+
+func TestAngelthumpBan(t *testing.T) {
+	f := &fakeBackend{status: http.StatusOK, body: `{}`}
+	c := newAngelthumpClient(f.start(t).URL, "token")
+	ctx := context.Background()
+
+	if _, err := c.banATuser(ctx, "abc", "", true); err != nil {
+		t.Fatal(err)
+	}
+	if f.path != "/v2/admin/ban" || f.sent != "reason=no+reason+provided&username=abc" {
+		t.Errorf("unexpected ban request %s %s", f.path, f.sent)
+	}
+	if got := f.header.Get("Authorization"); got != "key token" {
+		t.Errorf("expected admin token, got %q", got)
+	}
+
+	if _, err := c.banATuser(ctx, "abc", "", false); err != nil || f.path != "/v2/admin/unban" {
+		t.Errorf("unexpected unban %s, %v", f.path, err)
+	}
+
+	f.body = `{"error":true,"errorMSG":"user is not banned"}`
+	if _, err := c.banATuser(ctx, "abc", "", false); err == nil || err.Error() != "status code 200: user is not banned" {
+		t.Errorf("expected backend error, got %v", err)
+	}
+
+	f.status, f.body = http.StatusUnauthorized, "unauthorized"
+	var apiErr *apiError
+	if _, err := c.banATuser(ctx, "abc", "", true); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected a 401 apiError, got %v", err)
+	}
+}
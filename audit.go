@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/MemeLabs/dggchat"
+)
+
+const (
+	// maxAuditEntries are kept in memory for !modlog.
+	maxAuditEntries = 1000
+	modlogLines     = 5
+)
+
+// auditEntry is a moderation action the bot performed or observed.
+type auditEntry struct {
+	Time time.Time `json:"time"`
+	// Action is one of mute, unmute, ban, unban, warn, nuke, aegis, rename,
+	// modify, drop or undrop.
+	Action   string   `json:"action"`
+	Actor    string   `json:"actor"`
+	Target   string   `json:"target"`
+	Reason   string   `json:"reason,omitempty"`
+	Duration duration `json:"duration"`
+	// Origin is chat or pm for commands, the rule or nuke for automatic
+	// actions and observed for actions by others.
+	Origin string `json:"origin"`
+}
+
+func (e auditEntry) String() string {
+	out := fmt.Sprintf("%s %s", e.Action, e.Target)
+	if e.Duration.Duration > 0 {
+		out += " " + formatDuration(e.Duration.Duration)
+	}
+	out += fmt.Sprintf(" by %s (%s)", e.Actor, e.Origin)
+	if e.Reason != "" {
+		out += ": " + e.Reason
+	}
+	return out
+}
+
+// auditLog is an append-only JSONL file of moderation actions. The latest
+// entries are kept in memory.
+type auditLog struct {
+	file    *os.File
+	entries []auditEntry
+}
+
+// openAuditLog opens or creates the log at path, an empty path keeps entries
+// in memory only.
+func openAuditLog(path string) (*auditLog, error) {
+	a := &auditLog{}
+	if path == "" {
+		return a, nil
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e auditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			eventLog.warnf("skipping broken audit entry: %s\n", err.Error())
+			continue
+		}
+		a.add(e)
+	}
+	if err := scanner.Err(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	a.file = f
+	return a, nil
+}
+
+func (a *auditLog) add(e auditEntry) {
+	if len(a.entries) >= maxAuditEntries {
+		a.entries = a.entries[1:]
+	}
+	a.entries = append(a.entries, e)
+}
+
+func (a *auditLog) record(e auditEntry) error {
+	a.add(e)
+	if a.file == nil {
+		return nil
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = a.file.Write(append(data, '\n'))
+	return err
+}
+
+// find returns the latest n entries by or against nick, newest first.
+func (a *auditLog) find(nick string, n int) []auditEntry {
+	found := []auditEntry{}
+	for i := len(a.entries) - 1; i >= 0 && len(found) < n; i-- {
+		e := a.entries[i]
+		if strings.EqualFold(e.Target, nick) || strings.EqualFold(e.Actor, nick) {
+			found = append(found, e)
+		}
+	}
+	return found
+}
+
+func (a *auditLog) close() error {
+	if a.file == nil {
+		return nil
+	}
+	return a.file.Close()
+}
+
+// audit records a moderation action, callers hold b.mu.
+func (b *bot) audit(e auditEntry) {
+	if e.Time.IsZero() {
+		e.Time = b.now()
+	}
+	if err := b.modlog.record(e); err != nil {
+		eventLog.errorf("audit log error: %s\n", err.Error())
+	}
+}
+
+// auditObserved records actions by others the chat tells us about. Our own
+// actions were recorded when we performed them.
+func (b *bot) auditObserved(action string, sender, target dggchat.User, t time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.nick != "" && strings.EqualFold(sender.Nick, b.nick) {
+		return
+	}
+	b.audit(auditEntry{Time: t, Action: action, Actor: sender.Nick, Target: target.Nick, Origin: "observed"})
+}
+
+// !modlog username - list the latest moderation actions by or against a
+// user via PM.
+func (b *bot) modlogCommand(m dggchat.Message, s chatSession) {
+	parts := strings.Fields(m.Message)
+	if len(parts) < 2 {
+		return
+	}
+	nick := parts[1]
+
+	entries := b.modlog.find(nick, modlogLines)
+	if len(entries) == 0 {
+		s.SendPrivateMessage(m.Sender.Nick, fmt.Sprintf("no moderation actions for %s", nick))
+		return
+	}
+	lines := []string{}
+	for _, e := range entries {
+		lines = append(lines, fmt.Sprintf("%s ago %s",
+			formatDuration(b.now().Sub(e.Time).Truncate(time.Second)), e))
+	}
+	for _, msg := range joinMessages("", lines, maxMessageLength) {
+		s.SendPrivateMessage(m.Sender.Nick, msg)
+	}
+}
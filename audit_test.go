@@ -0,0 +1,102 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MemeLabs/dggchat"
+)
+
+func TestAuditLogFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "modbot-audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.jsonl")
+
+	a, err := openAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	entries := []auditEntry{
+		{Time: now, Action: "mute", Actor: "mod", Target: "a", Duration: duration{time.Minute}, Origin: "chat"},
+		{Time: now, Action: "ban", Actor: "mod", Target: "b", Reason: "spam", Origin: "pm"},
+		{Time: now, Action: "unmute", Actor: "mod", Target: "A", Origin: "chat"},
+	}
+	for _, e := range entries {
+		if err := a.record(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	a.close()
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(data), "\n"); lines != 3 {
+		t.Errorf("expected 3 JSON lines, got %d:\n%s", lines, data)
+	}
+
+	reopened, err := openAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.close()
+	found := reopened.find("a", 5)
+	if len(found) != 2 || found[0].Action != "unmute" || found[1] != entries[0] {
+		t.Errorf("unexpected entries for a: %v", found)
+	}
+	if found := reopened.find("mod", 2); len(found) != 2 {
+		t.Errorf("expected 2 entries by mod, got %v", found)
+	}
+}
+
+func TestModlog(t *testing.T) {
+	b, s := newTestBot()
+	b.nick = "bot"
+	say(b, s, chatter("a"), "badword")
+	whisper(b, s, testMod, "!nuke 1h badword")
+	say(b, s, testMod, "!unmute a")
+	b.onBan(dggchat.Ban{Sender: chatter("othermod"), Target: chatter("a"), Timestamp: time.Now()}, nil)
+	b.onBan(dggchat.Ban{Sender: chatter("bot"), Target: chatter("a"), Timestamp: time.Now()}, nil)
+	s.take()
+
+	say(b, s, testMod, "!modlog a")
+	pms := s.find("PRIVMSG")
+	if len(pms) != 1 {
+		t.Fatalf("expected one PM, got %v", pms)
+	}
+	for _, want := range []string{
+		"ban a by othermod (observed)",
+		"unmute a by mod (chat)",
+		"mute a 1hour by mod (nuke #1): badword",
+	} {
+		if !strings.Contains(pms[0].Message, want) {
+			t.Errorf("expected %q in %q", want, pms[0].Message)
+		}
+	}
+	if strings.Contains(pms[0].Message, "by bot") {
+		t.Errorf("our own actions shouldn't be recorded twice: %q", pms[0].Message)
+	}
+
+	found := b.modlog.find("badword", 1)
+	if len(found) != 1 || found[0].Action != "nuke" || found[0].Origin != "pm" {
+		t.Errorf("expected the nuke issued via PM, got %v", found)
+	}
+}
+
+func TestAuditPublicPM(t *testing.T) {
+	b, s := newTestBot()
+	whisper(b, s, testMod, "!mute a 1h --public")
+	found := b.modlog.find("a", 1)
+	if len(found) != 1 || found[0].Origin != "pm" {
+		t.Errorf("expected a public command issued via PM to be audited as pm, got %v", found)
+	}
+}
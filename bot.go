@@ -1,91 +1,177 @@
 package main
 
 import (
-	"log"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/MemeLabs/dggchat"
 )
 
 type bot struct {
-	log             []dggchat.Message
-	maxLogLines     int
-	parsers         []func(m dggchat.Message, s *dggchat.Session)
-	lastNukeVictims []string
-	randomizer      int
-	authCookie      string
+	// mu guards the state below against background goroutines (state saver,
+	// rules watcher), chat handlers themselves are called sequentially.
+	mu            sync.Mutex
+	store         stateStore
+	log           []dggchat.Message
+	maxLogLines   int
+	commands      []*command
+	commandLookup map[string]*command
+	hooks         []commandHandler
+	rules         []*rule
+	raid          *raidConfig
+	pendingRaid   *raidAlert
+	offences      map[string][]offence
+	// ruleWarnings is when a warn-only rule last warned, by rule and nick.
+	ruleWarnings map[string]time.Time
+	ladder       *ladderConfig
+	roles        *roleConfig
+	modlog       *auditLog
+	// nick is the bot's own chat name, if known.
+	nick       string
+	nukes      []*nukeEntry
+	nextNukeID int
+	// out queues outgoing messages, nil sends them right away.
+	out    *outbox
+	strims *strimsClient
+	at     *angelthumpClient
+	// now is the bot's clock, replaced when replaying chat logs.
+	now    func() time.Time
+	health *health
+	// origin is where the message being handled came from, chat or pm.
+	origin string
+	// prereqs are the preflight results, nil until checked.
+	prereqs map[prereq]error
+	// cfg is the current config, replaced by reloads.
+	cfg *config
+	// readConfig reads the config again for reloads, nil disables them.
+	readConfig func() (*config, error)
+	// logs are the open log files, nil if logging isn't set up by the bot.
+	logs logFiles
+	// conn is the chat connection, nil until connected.
+	conn *chatConn
 }
 
-func newBot(authCookie string, maxLogLines int) *bot {
+func newBot(maxLogLines int) *bot {
 	if maxLogLines < 0 {
 		maxLogLines = 0
 	}
 
 	b := bot{
-		log:         make([]dggchat.Message, maxLogLines),
-		maxLogLines: maxLogLines,
-		randomizer:  0, // TODO workaround for dup msgs, remove me...
-		authCookie:  authCookie,
+		log:          make([]dggchat.Message, maxLogLines),
+		maxLogLines:  maxLogLines,
+		rules:        defaultRules(),
+		raid:         defaultRaidConfig(),
+		offences:     map[string][]offence{},
+		ruleWarnings: map[string]time.Time{},
+		ladder:       defaultLadderConfig(),
+		roles:        defaultRoleConfig(),
+		modlog:       &auditLog{},
+		now:          time.Now,
+		health:       newHealth(0),
+		cfg:          defaultConfig(),
 	}
 	return &b
 }
 
-func (b *bot) addParser(p ...func(m dggchat.Message, s *dggchat.Session)) {
-	b.parsers = append(b.parsers, p...)
+// registerDefaults registers all built-in commands and message hooks.
+func (b *bot) registerDefaults() {
+	b.registerCommands(b.defaultCommands()...)
+	b.addHook(b.enforceNukes, b.detectRaid, b.applyRules)
 }
 
 func (b *bot) onMessage(m dggchat.Message, s *dggchat.Session) {
+	b.handleMessage(m, s)
+}
+
+func (b *bot) handleMessage(m dggchat.Message, s chatSession) {
+	if b.out != nil {
+		s = b.out.wrap(s)
+		defer b.out.flush()
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.origin = "chat"
+
 	// remember maxLogLines messages
 	if len(b.log) >= b.maxLogLines {
 		b.log = b.log[1:]
 	}
 	b.log = append(b.log, m)
 
-	log.Printf("%s: %s\n", m.Sender.Nick, m.Message)
+	chatLog.message(m.Sender.Nick, m.Message, m.Sender.Features, m.Timestamp, false)
+	messagesSeen.inc()
+	b.health.sawMessage()
 
-	for _, p := range b.parsers {
-		p(m, s)
+	for _, h := range b.hooks {
+		h(m, s)
 	}
+	b.dispatch(m, s)
 }
 
 func (b *bot) onError(e string, s *dggchat.Session) {
-	log.Printf("[#] error: '%s'\n", e)
+	eventLog.warnf("chat error: '%s'\n", e)
+	if b.out != nil {
+		b.out.onError(e)
+	}
 }
 
 func (b *bot) onMute(m dggchat.Mute, s *dggchat.Session) {
-	log.Printf("[#] mute: '%s' by '%s'\n", m.Target.Nick, m.Sender.Nick)
+	eventLog.infof("mute: '%s' by '%s'\n", m.Target.Nick, m.Sender.Nick)
+	b.auditObserved("mute", m.Sender, m.Target, m.Timestamp)
 }
 
 func (b *bot) onUnmute(m dggchat.Mute, s *dggchat.Session) {
-	log.Printf("[#] unmute: '%s' by '%s'\n", m.Target.Nick, m.Sender.Nick)
+	eventLog.infof("unmute: '%s' by '%s'\n", m.Target.Nick, m.Sender.Nick)
+	b.auditObserved("unmute", m.Sender, m.Target, m.Timestamp)
 }
 
 func (b *bot) onBan(m dggchat.Ban, s *dggchat.Session) {
-	log.Printf("[#] ban: '%s' by '%s'\n", m.Target.Nick, m.Sender.Nick)
+	eventLog.infof("ban: '%s' by '%s'\n", m.Target.Nick, m.Sender.Nick)
+	b.auditObserved("ban", m.Sender, m.Target, m.Timestamp)
 }
 
 func (b *bot) onUnban(m dggchat.Ban, s *dggchat.Session) {
-	log.Printf("[#] unban: '%s' by '%s'\n", m.Target.Nick, m.Sender.Nick)
+	eventLog.infof("unban: '%s' by '%s'\n", m.Target.Nick, m.Sender.Nick)
+	b.auditObserved("unban", m.Sender, m.Target, m.Timestamp)
 }
 
-func (b *bot) onSocketError(err error, s *dggchat.Session) {
-	log.Printf("[#] socket error: '%s'\n", err.Error())
+func (b *bot) onPMHandler(m dggchat.PrivateMessage, s *dggchat.Session) {
+	b.handlePM(m, s)
 }
 
-func (b *bot) onPMHandler(m dggchat.PrivateMessage, s *dggchat.Session) {
-	log.Printf("[#] PM: %s: %s\n", m.User.Nick, m.Message)
-
-	if isMod(m.User) {
-		// handle PM as command, TODO: rules shouldn't be handled here...
-		msg := dggchat.Message{
-			Sender:    m.User,
-			Timestamp: m.Timestamp,
-			Message:   m.Message,
-		}
+func (b *bot) handlePM(m dggchat.PrivateMessage, s chatSession) {
+	chatLog.message(m.User.Nick, m.Message, m.User.Features, m.Timestamp, true)
+	b.health.sawMessage()
 
-		for _, p := range b.parsers {
-			p(msg, s)
-		}
+	if b.out != nil {
+		s = b.out.wrap(s)
+		defer b.out.flush()
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.origin = "pm"
+
+	// PM commands are for privileged users only.
+	if b.roleOf(m.User) == roleEveryone {
+		return
+	}
+
+	// handle PM as command, rules only apply to chat messages.
+	msg := dggchat.Message{
+		Sender:    m.User,
+		Timestamp: m.Timestamp,
+		Message:   m.Message,
+	}
+	// answer in kind, unless asked to broadcast.
+	if text := strings.TrimSpace(m.Message); strings.HasSuffix(text, " "+publicSuffix) {
+		msg.Message = strings.TrimSpace(strings.TrimSuffix(text, publicSuffix))
+	} else {
+		s = pmSession{chatSession: s, nick: m.User.Nick}
 	}
+	b.dispatch(msg, s)
 }
 
 // return last n messsages for given user from log
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func connectTestBot(t *testing.T, c *mockChat) *bot {
+	t.Helper()
+	b, _ := connectTestConn(t, c, 0, nil)
+	return b
+}
+
+func connectTestConn(t *testing.T, c *mockChat, maxFailures int, fatal func(error)) (*bot, *chatConn) {
+	t.Helper()
+	oldMin, oldMax := reconnectMinBackoff, reconnectMaxBackoff
+	reconnectMinBackoff, reconnectMaxBackoff = time.Millisecond, 10*time.Millisecond
+	t.Cleanup(func() { reconnectMinBackoff, reconnectMaxBackoff = oldMin, oldMax })
+
+	b := newBot(250)
+	b.registerDefaults()
+	conn, err := b.connect("secret", c.url(), maxFailures)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fatal != nil {
+		conn.mu.Lock()
+		conn.fatal = fatal
+		conn.mu.Unlock()
+	}
+	t.Cleanup(func() { conn.Close() })
+	c.waitConnected()
+	return b, conn
+}
+
+func TestChatNukeEndToEnd(t *testing.T) {
+	c := newMockChat(t, testMod, testUser)
+	connectTestBot(t, c)
+
+	if cookie := c.cookies[0]; !strings.Contains(cookie, "jwt=secret") {
+		t.Errorf("expected the auth cookie, got %q", cookie)
+	}
+
+	c.say(chatter("a"), "buy badword now")
+	c.say(testUser, "hello")
+	c.say(testMod, "!nuke 1h badword")
+
+	mute := c.expect("MUTE", "a")
+	if mute.Duration != time.Hour {
+		t.Errorf("expected a 1h mute, got %s", mute.Duration)
+	}
+	if msg := c.expect("MSG", ""); !strings.HasPrefix(msg.Message, "nuked 1 user") {
+		t.Errorf("unexpected nuke message %q", msg.Message)
+	}
+	c.expect("PRIVMSG", "mod")
+
+	c.say(testUser, "!nuke hello")
+	c.expectNothing()
+}
+
+func TestChatPMCommands(t *testing.T) {
+	c := newMockChat(t, testMod, testUser)
+	connectTestBot(t, c)
+
+	// features of PM senders come from NAMES.
+	c.whisper(testUser, "!mute mod 5m")
+	c.expectNothing()
+
+	c.whisper(testMod, "!mute user 5m")
+	if mute := c.expect("MUTE", "user"); mute.Duration != 5*time.Minute {
+		t.Errorf("expected a 5m mute, got %s", mute.Duration)
+	}
+	c.whisper(testMod, "!ban user spam")
+	if ban := c.expect("BAN", "user"); ban.Message != "spam" {
+		t.Errorf("expected ban reason spam, got %q", ban.Message)
+	}
+	c.whisper(testMod, "!unban user")
+	c.expect("UNBAN", "user")
+}
+
+func TestChatRaidAlertsOnlineMods(t *testing.T) {
+	c := newMockChat(t, testMod, testUser)
+	connectTestBot(t, c)
+
+	for _, nick := range []string{"r1", "r2", "r3", "r4"} {
+		c.say(chatter(nick), "come check out my totally legit website everyone")
+	}
+	c.expect("PRIVMSG", "mod")
+	c.say(testMod, "!raid")
+	muted := map[string]bool{}
+	for i := 0; i < 4; i++ {
+		select {
+		case a := <-c.received:
+			muted[a.Nick] = a.Type == "MUTE"
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for raid mutes")
+		}
+	}
+	for _, nick := range []string{"r1", "r2", "r3", "r4"} {
+		if !muted[nick] {
+			t.Errorf("expected %s to be muted, got %v", nick, muted)
+		}
+	}
+}
+
+func TestChatReconnect(t *testing.T) {
+	c := newMockChat(t, testMod, testUser)
+	connectTestBot(t, c)
+
+	// server events and errors are only logged.
+	c.event("MUTE", testMod, "user")
+	c.event("BAN", testMod, "user")
+	c.errorMessage("throttled")
+
+	c.drop(0)
+	c.waitConnected()
+
+	c.say(testMod, "!unmute user")
+	c.expect("UNMUTE", "user")
+}
+
+func TestChatReconnectAfterFailures(t *testing.T) {
+	c := newMockChat(t, testMod, testUser)
+	_, conn := connectTestConn(t, c, 5, func(err error) {
+		t.Errorf("unexpected fatal error %v", err)
+	})
+
+	c.drop(3)
+	c.waitConnected()
+	c.say(testMod, "!unmute user")
+	c.expect("UNMUTE", "user")
+
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	if conn.outages != 1 {
+		t.Errorf("expected 1 outage, got %d", conn.outages)
+	}
+}
+
+func TestChatReconnectGivesUp(t *testing.T) {
+	c := newMockChat(t, testMod, testUser)
+	fatal := make(chan error, 1)
+	connectTestConn(t, c, 3, func(err error) { fatal <- err })
+
+	c.drop(100)
+	select {
+	case err := <-fatal:
+		if !strings.Contains(err.Error(), "after 3 reconnect attempts") {
+			t.Errorf("unexpected error %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the bot to give up")
+	}
+}
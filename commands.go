@@ -1,11 +1,12 @@
 package main
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"math"
 	"net/http"
 	"regexp"
@@ -21,110 +22,242 @@ var (
 	commands = map[string]string{}
 )
 
-func isMod(user dggchat.User) bool {
-	return user.HasFeature("moderator") || user.HasFeature("admin")
-}
-
-// TODO
-func (b *bot) sendMessageDedupe(m string, s *dggchat.Session) {
-	if logOnly {
-		log.Printf("[##] LOGONLY reply: %s\n", m)
-		return
-	}
-
-	b.randomizer++
-	rnd := " " + strings.Repeat(".", b.randomizer%2)
-	err := s.SendMessage(m + rnd)
-	if err != nil {
-		log.Printf("[##] send error: %s\n", err.Error())
+// defaultCommands returns all built-in chat commands.
+func (b *bot) defaultCommands() []command {
+	return []command{
+		{
+			name:    "!modify",
+			args:    "{service/username, username} [nsfw|hidden|afk|promoted]...",
+			example: "!modify youtube/6n3pFFPSlW4 hidden !nsfw",
+			role:    roleMod,
+			needs:   []prereq{prereqAdmin},
+			help:    "Change stream attributes. To invert options (remove modifier), prefix with \"!\".",
+			handler: b.modifyStream,
+		},
+		{
+			name:    "!raid",
+			role:    roleMod,
+			help:    "Nuke the raid the bot alerted about via PM.",
+			handler: b.confirmRaid,
+		},
+		{
+			name:    "!offences",
+			args:    "username",
+			example: "!offences ihatememes",
+			role:    roleMod,
+			help:    "List a chatter's recent offences via PM.",
+			handler: b.listOffences,
+		},
+		{
+			name:    "!modlog",
+			args:    "username",
+			example: "!modlog ihatememes",
+			role:    roleMod,
+			help:    "List the latest moderation actions by or against a user via PM.",
+			handler: b.modlogCommand,
+		},
+		{
+			name:    "!status",
+			role:    roleMod,
+			help:    "Show the startup checks, chat connection and commands disabled by failed checks.",
+			handler: b.status,
+		},
+		{
+			name:    "!reload",
+			role:    roleMod,
+			help:    "Reload the config file and the commands, rules, roles and logging files it names.",
+			handler: b.reloadCommand,
+		},
+		{
+			name:    "!perms",
+			args:    "[username [role]]",
+			example: "!perms ilovememes trusted",
+			role:    roleAdmin,
+			help:    "Show or grant bot roles (everyone, trusted, mod, admin), everyone revokes.",
+			handler: b.perms,
+		},
+		{
+			name:    "!rename",
+			args:    "oldUsername newUsername",
+			example: "!rename ihatememes ilovememes",
+			role:    roleAdmin,
+			needs:   []prereq{prereqAdmin},
+			help:    "User has to reconnect after. Alternatively ban for 1 second.",
+			handler: b.rename,
+		},
+		{
+			name:    "!addcommand",
+			args:    "[!]commandname [output|_]",
+			example: "!addcommand test i like tests",
+			role:    roleAdmin,
+			help:    "Using \"_\" as output removes the given command.",
+			handler: b.addCommand,
+		},
+		{
+			name:    "!say",
+			args:    "string",
+			example: "!say something nice",
+			role:    roleAdmin,
+			public:  true,
+			help:    "Say something as the bot.",
+			handler: b.say,
+		},
+		{
+			name:    "!mute",
+			args:    "username [duration]",
+			example: "!mute ihatememes 1h",
+			role:    roleMod,
+			help:    "Without duration, repeat offenders get escalating punishments.",
+			handler: b.mute,
+		},
+		{
+			name:    "!unmute",
+			args:    "username",
+			example: "!unmute ihatememes",
+			role:    roleMod,
+			help:    "Unmute a chatter.",
+			handler: b.unmute,
+		},
+		{
+			name:    "!ban",
+			args:    "username [reason]",
+			example: "!ban ihatememes spam",
+			role:    roleMod,
+			help:    "Ban a chatter.",
+			handler: b.ban,
+		},
+		{
+			name:    "!unban",
+			args:    "username",
+			example: "!unban ihatememes",
+			role:    roleMod,
+			help:    "Unban a chatter, also removes mutes.",
+			handler: b.ban,
+		},
+		{
+			name:    "!nuke",
+			args:    "[duration] [window] string",
+			example: "!nuke 30m 2m badword123",
+			role:    roleMod,
+			help:    "Mute everyone who said the string within the window (default 5m) for duration (default 10m). Stays armed for the duration, muting anyone repeating it.",
+			handler: b.nuke,
+		},
+		{
+			name:    "!nukeregex",
+			args:    "[duration] [window] regexp",
+			example: "!nukeregex 1h (MiyanoHype ){10,}",
+			role:    roleMod,
+			help:    "Like !nuke, but matches messages against the regexp.",
+			handler: b.nuke,
+		},
+		{
+			name:    "!nukes",
+			role:    roleMod,
+			help:    "List nukes which can still be undone, via PM.",
+			handler: b.listNukes,
+		},
+		{
+			name:    "!aegis",
+			args:    "[id]",
+			example: "!aegis 3",
+			role:    roleMod,
+			help:    "Undo the given nuke, or the latest one.",
+			handler: b.aegis,
+		},
+		{
+			name:    "!aegisall",
+			role:    roleMod,
+			help:    "Undo all past nukes.",
+			handler: b.aegisAll,
+		},
+		{
+			name:    "!drop",
+			args:    "AT_name reason",
+			example: "!drop test stream sniping",
+			role:    roleAdmin,
+			needs:   []prereq{prereqAngelthump},
+			help:    "Ban user from angelthump service.",
+			handler: b.dropAT,
+		},
+		{
+			name:    "!undrop",
+			args:    "AT_name",
+			example: "!undrop test",
+			role:    roleAdmin,
+			needs:   []prereq{prereqAngelthump},
+			help:    "Unban user from angelthump service.",
+			handler: b.dropAT,
+		},
+		{
+			name:    "!stream",
+			aliases: []string{"!streams", "!strim", "!strims"},
+			role:    roleEveryone,
+			help:    "Prints list of top streams.",
+			handler: b.printTopStreams,
+		},
+		{
+			name:    "!check",
+			args:    "AT_name",
+			example: "!check test",
+			role:    roleEveryone,
+			help:    "Check status of an AT stream.",
+			handler: b.checkAT,
+		},
+		{
+			name:    "!alt",
+			args:    "AT_name server",
+			example: "!alt test nyc",
+			role:    roleEveryone,
+			help:    "Link to the stream on an alternative AT server.",
+			handler: b.provideAltAngelthumpLink,
+		},
+		{
+			name:    "!sudoku",
+			role:    roleEveryone,
+			help:    "Mute yourself.",
+			handler: b.sudoku,
+		},
+		{
+			name:    "!frenchToastAlert",
+			role:    roleEveryone,
+			help:    "Current french toast alert level.",
+			handler: b.frenchToastAlert,
+		},
+		{
+			name:    "!help",
+			args:    "[command]",
+			example: "!help check",
+			role:    roleEveryone,
+			help:    "List commands or show usage of a command. Privileged commands are answered via PM.",
+			handler: b.help,
+		},
 	}
 }
 
-func (b *bot) staticMessage(m dggchat.Message, s *dggchat.Session) {
-	for command, response := range commands {
-		if strings.HasPrefix(m.Message, command) {
-
-			b.sendMessageDedupe(response, s)
-			// only handle the first match
-			return
-		}
-	}
-}
-
-// !nuke str, !nukeregex regexp
-func (b *bot) nuke(m dggchat.Message, s *dggchat.Session) {
-	if !isMod(m.Sender) || !strings.HasPrefix(m.Message, "!nuke") {
-		return
-	}
-
-	parts := strings.SplitN(m.Message, " ", 2)
-	if len(parts) <= 1 {
-		return
-	}
-
-	isRegexNuke := parts[0] == "!nukeregex"
-	badstr := parts[1]
-	badregexp, err := regexp.Compile(badstr) // TODO when is error not nil??
-	if isRegexNuke && err != nil {
-		b.sendMessageDedupe("regexp error", s)
+func (b *bot) sendMessage(m string, s chatSession) {
+	if b.cfg.LogOnly {
+		eventLog.infof("LOGONLY reply: %s\n", m)
 		return
 	}
 
-	// find anyone saying badstr
-	// TODO limit by time, not amout of messages...
-	victimNames := []string{}
-	// the command itself will be last in the log and caught, exclude that one.
-	// TODO: except if the command was issued via PM...
-	for _, m := range b.log[:len(b.log)-1] {
-		// don't nuke mods.
-		if isMod(m.Sender) {
-			continue
-		}
-
-		var isBad bool
-		if isRegexNuke {
-			isBad = badregexp.MatchString(m.Message)
-		} else {
-			isBad = strings.Contains(m.Message, badstr)
-		}
-
-		if isBad {
-			// TODO dont collect duplicates...
-			// collect names in case we want to revert nuke
-			victimNames = append(victimNames, m.Sender.Nick)
-
-			log.Printf("[##] Nuking '%s' because of message '%s' with nuke '%s'\n",
-				m.Sender.Nick, m.Message, badstr)
-
-			// TODO duration, -1 means server default
-			s.SendMute(m.Sender.Nick, -1)
-		}
-		// TODO print/send summary?
-	}
-
-	if b.lastNukeVictims == nil {
-		b.lastNukeVictims = []string{}
+	err := s.SendMessage(m)
+	if err != nil {
+		eventLog.errorf("send error: %s\n", err.Error())
 	}
-	// combine array so we are able to undo all past nukes at once, if necessary
-	b.lastNukeVictims = append(b.lastNukeVictims, victimNames...)
 }
 
-func (b *bot) sudoku(m dggchat.Message, s *dggchat.Session) {
-	if !strings.HasPrefix(m.Message, "!sudoku") {
-		return
-	}
+func (b *bot) sudoku(m dggchat.Message, s chatSession) {
 	// TODO duration, -1 means server default
 	s.SendMute(m.Sender.Nick, -1)
+	b.audit(auditEntry{Action: "mute", Actor: m.Sender.Nick, Target: m.Sender.Nick,
+		Reason: "!sudoku", Origin: b.origin})
 }
 
-func (b *bot) frenchToastAlert(m dggchat.Message, s *dggchat.Session) {
+func (b *bot) frenchToastAlert(m dggchat.Message, s chatSession) {
 	type ftlXML struct {
 		XMLName xml.Name `xml:"frenchtoast"`
 		Status  string   `xml:"status"`
 	}
-	if !strings.HasPrefix(m.Message, "!frenchToastAlert") {
-		return
-	}
 	//get frenchToastAlert XML
 	resp, err := http.Get("https://www.universalhub.com/toast.xml")
 	if err != nil {
@@ -132,34 +265,18 @@ func (b *bot) frenchToastAlert(m dggchat.Message, s *dggchat.Session) {
 	}
 	//Parse Alert
 	if resp.StatusCode != 200 {
-		b.sendMessageDedupe("Error reaching French Toast Alert", s)
+		b.sendMessage("Error reaching French Toast Alert", s)
 		return
 	}
 	byteValue, _ := ioutil.ReadAll(resp.Body)
 	var f ftlXML
 	xml.Unmarshal(byteValue, &f)
 	//Bot says Alert
-	b.sendMessageDedupe("Current FT Level is "+f.Status, s)
-}
-
-// !aegis - undo (all) past nukes
-func (b *bot) aegis(m dggchat.Message, s *dggchat.Session) {
-	if !isMod(m.Sender) || !strings.HasPrefix(m.Message, "!aegis") || b.lastNukeVictims == nil {
-		return
-	}
-
-	for _, nick := range b.lastNukeVictims {
-		s.SendUnmute(nick)
-	}
-	b.lastNukeVictims = nil
+	b.sendMessage("Current FT Level is "+f.Status, s)
 }
 
 // !rename - change a chatter's username
-func (b *bot) rename(m dggchat.Message, s *dggchat.Session) {
-	if !isMod(m.Sender) || !strings.HasPrefix(m.Message, "!rename") {
-		return
-	}
-
+func (b *bot) rename(m dggchat.Message, s chatSession) {
 	parts := strings.Split(m.Message, " ")
 	if len(parts) < 3 {
 		return
@@ -167,75 +284,70 @@ func (b *bot) rename(m dggchat.Message, s *dggchat.Session) {
 
 	oldName := parts[1]
 	newName := parts[2]
-	err := b.renameUser(oldName, newName)
+	err := b.strims.renameUser(context.Background(), oldName, newName)
 	if err != nil {
 		msg := fmt.Sprintf("'%s' to '%s' by %s failed with '%s'",
 			oldName, newName, m.Sender.Nick, err.Error())
-		log.Printf("[##] rename: %s\n", msg)
+		eventLog.infof("rename: %s\n", msg)
 
 		s.SendPrivateMessage(m.Sender.Nick, msg)
-		b.sendMessageDedupe("rename error, check logs", s)
+		b.sendMessage("rename error, check logs", s)
 		return
 	}
-	log.Printf("[##] rename: '%s' to '%s' by '%s' success!\n",
+	eventLog.infof("rename: '%s' to '%s' by '%s' success!\n",
 		oldName, newName, m.Sender.Nick)
-	b.sendMessageDedupe(fmt.Sprintf("name changed, %s please reconnect", oldName), s)
+	b.audit(auditEntry{Action: "rename", Actor: m.Sender.Nick, Target: oldName,
+		Reason: "renamed to " + newName, Origin: b.origin})
+	b.sendMessage(fmt.Sprintf("name changed, %s please reconnect", oldName), s)
 }
 
 // !say - say a message
-func (b *bot) say(m dggchat.Message, s *dggchat.Session) {
-	if !isMod(m.Sender) || !strings.HasPrefix(m.Message, "!say") {
-		return
-	}
-
+func (b *bot) say(m dggchat.Message, s chatSession) {
 	// message itself can contain spaces
 	parts := strings.SplitN(m.Message, " ", 2)
 	if len(parts) != 2 {
 		return
 	}
-	b.sendMessageDedupe(parts[1], s)
+	b.sendMessage(parts[1], s)
 }
 
 // !mute - mute a chatter for a given time
-func (b *bot) mute(m dggchat.Message, s *dggchat.Session) {
-	if !isMod(m.Sender) || !strings.HasPrefix(m.Message, "!mute") {
-		return
-	}
+func (b *bot) mute(m dggchat.Message, s chatSession) {
 	parts := strings.Split(m.Message, " ")
 	if len(parts) < 2 {
 		return
 	}
 
-	var duration time.Duration = -1
+	source := fmt.Sprintf("!mute by %s", m.Sender.Nick)
 	if len(parts) >= 3 {
 		dur, err := time.ParseDuration(parts[2])
-		if err != nil {
-			log.Printf("failed to parse duration %q: %v. Using default time", parts[2], err)
-		} else {
-			duration = dur
+		if err == nil {
+			// an explicit duration is not escalated.
+			b.recordOffence(parts[1], "manual mute", source)
+			s.SendMute(parts[1], dur)
+			b.audit(auditEntry{Action: "mute", Actor: m.Sender.Nick, Target: parts[1],
+				Duration: duration{dur}, Origin: b.origin})
+			return
 		}
+		eventLog.warnf("failed to parse duration %q: %v. Using default time", parts[2], err)
 	}
-	s.SendMute(parts[1], duration)
+	action, d := b.punish(parts[1], "manual mute", source, 0, s)
+	b.audit(auditEntry{Action: action, Actor: m.Sender.Nick, Target: parts[1],
+		Duration: duration{d}, Origin: b.origin})
 }
 
 // !unmute - unmute a chatter
-func (b *bot) unmute(m dggchat.Message, s *dggchat.Session) {
-	if !isMod(m.Sender) || !strings.HasPrefix(m.Message, "!unmute") {
-		return
-	}
+func (b *bot) unmute(m dggchat.Message, s chatSession) {
 	parts := strings.Split(m.Message, " ")
 	if len(parts) < 2 {
 		return
 	}
 	s.SendUnmute(parts[1])
+	b.audit(auditEntry{Action: "unmute", Actor: m.Sender.Nick, Target: parts[1], Origin: b.origin})
 }
 
 // !addcommand command response
-func (b *bot) addCommand(m dggchat.Message, s *dggchat.Session) {
-	if !isMod(m.Sender) || !strings.HasPrefix(m.Message, "!addcommand") {
-		return
-	}
-
+func (b *bot) addCommand(m dggchat.Message, s chatSession) {
 	// message itself can contain spaces
 	parts := strings.Split(m.Message, " ")
 	if len(parts) < 3 {
@@ -252,15 +364,15 @@ func (b *bot) addCommand(m dggchat.Message, s *dggchat.Session) {
 	// TODO workaround to enable deletion
 	if resp == "_" {
 		delete(commands, cmnd)
-		b.sendMessageDedupe("deleted commands if it existed", s)
+		b.sendMessage("deleted commands if it existed", s)
 	} else {
 		commands[cmnd] = resp
-		success := saveStaticCommands()
+		success := saveStaticCommands(b.cfg.Commands)
 		if success {
-			b.sendMessageDedupe(fmt.Sprintf("added new command %s", cmnd), s)
+			b.sendMessage(fmt.Sprintf("added new command %s", cmnd), s)
 			return
 		}
-		b.sendMessageDedupe("failed saving command, check logs", s)
+		b.sendMessage("failed saving command, check logs", s)
 	}
 }
 
@@ -271,15 +383,11 @@ func isCommunityStream(path string) bool {
 }
 
 // !stream or !strim(s) -- show top streams in chat
-func (b *bot) printTopStreams(m dggchat.Message, s *dggchat.Session) {
-	if !strings.HasPrefix(m.Message, "!stream") && !strings.HasPrefix(m.Message, "!strim") {
-		return
-	}
-
-	sd, err := b.getStreamList()
+func (b *bot) printTopStreams(m dggchat.Message, s chatSession) {
+	sd, err := b.strims.getStreamList(context.Background())
 	if err != nil {
-		log.Printf("%v\n", err)
-		b.sendMessageDedupe("error getting api data", s)
+		eventLog.errorf("stream list: %v\n", err)
+		b.sendMessage("error getting api data", s)
 		return
 	}
 
@@ -295,7 +403,7 @@ func (b *bot) printTopStreams(m dggchat.Message, s *dggchat.Session) {
 	// handle case that less than 3 streams are being watched...
 	maxlen := len(filteredStreams.StreamList)
 	if maxlen == 0 {
-		b.sendMessageDedupe("no streams are being watched", s)
+		b.sendMessage("no streams are being watched", s)
 		return
 	}
 	if maxlen > 3 {
@@ -313,8 +421,8 @@ func (b *bot) printTopStreams(m dggchat.Message, s *dggchat.Session) {
 			if data.Nsfw {
 				nsfw = " [nsfw]"
 			}
-			out := fmt.Sprintf("%d %s%s%s", data.Rustlers, websiteURL, data.URL, nsfw)
-			b.sendMessageDedupe(out, s)
+			out := fmt.Sprintf("%d %s%s%s", data.Rustlers, b.cfg.WebsiteURL, data.URL, nsfw)
+			b.sendMessage(out, s)
 			alreadyPrinted++
 		}
 	}
@@ -328,8 +436,8 @@ func (b *bot) printTopStreams(m dggchat.Message, s *dggchat.Session) {
 				nsfw = " [nsfw]"
 			}
 			data := filteredStreams.StreamList[i]
-			out := fmt.Sprintf("%d %s%s%s", data.Rustlers, websiteURL, data.URL, nsfw)
-			b.sendMessageDedupe(out, s)
+			out := fmt.Sprintf("%d %s%s%s", data.Rustlers, b.cfg.WebsiteURL, data.URL, nsfw)
+			b.sendMessage(out, s)
 			alreadyPrinted++
 		}
 	}
@@ -364,11 +472,7 @@ func parseModifiers(s []string) (streamModifier, error) {
 	return sm, nil
 }
 
-func (b *bot) modifyStream(m dggchat.Message, s *dggchat.Session) {
-	if !isMod(m.Sender) || !strings.HasPrefix(m.Message, "!modify") {
-		return
-	}
-
+func (b *bot) modifyStream(m dggchat.Message, s chatSession) {
 	//                       parts[2:], ...
 	// !modify youtube/memes nsfw !hidden ...
 	parts := strings.Split(m.Message, " ")
@@ -378,59 +482,56 @@ func (b *bot) modifyStream(m dggchat.Message, s *dggchat.Session) {
 
 	sm, err := parseModifiers(parts[2:])
 	if err != nil {
-		b.sendMessageDedupe(fmt.Sprintf("%s %s", err.Error(), ominousEmote), s)
+		b.sendMessage(fmt.Sprintf("%s %s", err.Error(), b.cfg.OminousEmote), s)
 		return
 	}
 
 	identifier := parts[1]
 
-	err = b.setStreamAttributes(identifier, sm)
+	err = b.strims.setStreamAttributes(context.Background(), identifier, sm)
 	if err != nil {
-		log.Printf("[##] modify: '%s' with modifier '%+v' by '%s' failed with '%s'\n",
+		eventLog.errorf("modify: '%s' with modifier '%+v' by '%s' failed with '%s'\n",
 			identifier, sm, m.Sender.Nick, err.Error())
 
 		// TODO chat message less verbose
-		b.sendMessageDedupe(fmt.Sprintf("modify: %s %s", err, ominousEmote), s)
+		b.sendMessage(fmt.Sprintf("modify: %s %s", err, b.cfg.OminousEmote), s)
 		return
 	}
-	log.Printf("[##] modify: '%s' with modifier '%+v' by '%s' success!\n",
+	eventLog.infof("modify: '%s' with modifier '%+v' by '%s' success!\n",
 		identifier, sm, m.Sender.Nick)
-	b.sendMessageDedupe(fmt.Sprintf("modify success %s", ominousEmote), s)
+	b.audit(auditEntry{Action: "modify", Actor: m.Sender.Nick, Target: identifier,
+		Reason: strings.Join(parts[2:], " "), Origin: b.origin})
+	b.sendMessage(fmt.Sprintf("modify success %s", b.cfg.OminousEmote), s)
 }
 
 // !check ATusername
-func (b *bot) checkAT(m dggchat.Message, s *dggchat.Session) {
-	if !strings.HasPrefix(m.Message, "!check") {
-		return
-	}
-
+func (b *bot) checkAT(m dggchat.Message, s chatSession) {
 	parts := strings.Split(m.Message, " ")
 	if len(parts) != 2 {
 		return
 	}
 	username := parts[1]
 
-	atd, err := b.getATUserData(username)
+	atd, err := b.at.getATUserData(context.Background(), username)
 	if err != nil {
-		log.Printf("[##] checkAT error1: '%s'\n",
+		eventLog.errorf("checkAT error1: '%s'\n",
 			err.Error())
 
-		// workaround... depends on content of error message
-		if strings.Contains(err.Error(), "404") {
-			log.Printf("[##] check: not found\n")
+		if errors.Is(err, errATUserNotFound) {
+			eventLog.debugf("check: not found\n")
 			return
 		}
 
-		b.sendMessageDedupe("error getting api data", s)
+		b.sendMessage("error getting api data", s)
 		return
 	}
 
 	// additionally check strim data
-	sd, err := b.getStreamList()
+	sd, err := b.strims.getStreamList(context.Background())
 	if err != nil {
-		log.Printf("[##] checkAT error2: '%s'\n",
+		eventLog.errorf("checkAT error2: '%s'\n",
 			err.Error())
-		b.sendMessageDedupe("error getting api data", s)
+		b.sendMessage("error getting api data", s)
 		return
 	}
 
@@ -439,9 +540,9 @@ func (b *bot) checkAT(m dggchat.Message, s *dggchat.Session) {
 	for _, strim := range sd.StreamList {
 		if strim.Service == "angelthump" && strings.EqualFold(strim.Channel, username) {
 			viewerCount = strim.Rustlers
-			url = fmt.Sprintf("%s%s", websiteURL, strim.URL)
+			url = fmt.Sprintf("%s%s", b.cfg.WebsiteURL, strim.URL)
 			if strim.Hidden {
-				log.Printf("[##] check: not found\n")
+				eventLog.debugf("check: not found\n")
 				return
 			}
 		}
@@ -449,7 +550,7 @@ func (b *bot) checkAT(m dggchat.Message, s *dggchat.Session) {
 
 	// might be live on AT, but no rustlers: disregard.
 	if viewerCount == 0 {
-		log.Printf("[##] check: not found\n")
+		eventLog.debugf("check: not found\n")
 		return
 	}
 
@@ -461,15 +562,11 @@ func (b *bot) checkAT(m dggchat.Message, s *dggchat.Session) {
 		output += " nsfw"
 	}
 
-	b.sendMessageDedupe(output, s)
+	b.sendMessage(output, s)
 }
 
 // !(un)drop atUser
-func (b *bot) dropAT(m dggchat.Message, s *dggchat.Session) {
-	if !isMod(m.Sender) || (!strings.HasPrefix(m.Message, "!drop") && !strings.HasPrefix(m.Message, "!undrop")) {
-		return
-	}
-
+func (b *bot) dropAT(m dggchat.Message, s chatSession) {
 	parts := strings.SplitN(m.Message, " ", 3)
 	if len(parts) < 2 {
 		return
@@ -488,13 +585,24 @@ func (b *bot) dropAT(m dggchat.Message, s *dggchat.Session) {
 		reason = parts[2]
 	}
 
-	reply, err := b.banATuser(username, reason, doBan)
+	reply, err := b.at.banATuser(context.Background(), username, reason, doBan)
+	var apiErr *apiError
+	switch {
+	case errors.As(err, &apiErr) && (apiErr.StatusCode == http.StatusUnauthorized || apiErr.StatusCode == http.StatusForbidden):
+		// the token was rejected, disable the commands until the next reload.
+		b.setPrereq(prereqAngelthump, err)
+	case err == nil:
+		b.setPrereq(prereqAngelthump, nil)
+	}
 	if err != nil {
-		log.Println(fmt.Sprintf("[##] drop error: '%s'", err.Error()))
+		eventLog.errorf("drop error: '%s'\n", err.Error())
 		return
 	}
 
-	//	b.sendMessageDedupe(reply, s)
+	b.audit(auditEntry{Action: strings.TrimPrefix(parts[0], "!"), Actor: m.Sender.Nick, Target: username,
+		Reason: reason, Origin: b.origin})
+
+	//	b.sendMessage(reply, s)
 	s.SendPrivateMessage(m.Sender.Nick, reply)
 }
 
@@ -502,21 +610,8 @@ var playlistURLPattern = regexp.MustCompile(`https://([\w-]+)\.angelthump\.com/h
 
 // provideAltAngelthumpLink expects a stream and server name, returning an alternate link for a stream
 // https://strims.gg/m3u8/https://ams-haproxy.angelthump.com/hls/somuchforsubtlety/index.m3u8
-func (b *bot) provideAltAngelthumpLink(m dggchat.Message, s *dggchat.Session) {
-	servers := map[string]string{
-		"nyc": "nyc-haproxy",
-		"sfo": "sfo-haproxy",
-		"sgp": "sgp-haproxy",
-		"lon": "lon-haproxy",
-		"fra": "fra-haproxy",
-		"blr": "blr-haproxy",
-		"ams": "ams-haproxy",
-		"tor": "tor-haproxy",
-	}
-
-	if !strings.HasPrefix(m.Message, "!alt") {
-		return
-	}
+func (b *bot) provideAltAngelthumpLink(m dggchat.Message, s chatSession) {
+	servers := b.cfg.ATServers
 
 	failed := "must provide a stream and server: `!alt psrngafk ["
 	for k := range servers {
@@ -527,7 +622,7 @@ func (b *bot) provideAltAngelthumpLink(m dggchat.Message, s *dggchat.Session) {
 	// !alt f1tv nyc
 	parts := strings.Split(m.Message, " ")
 	if len(parts) <= 2 {
-		b.sendMessageDedupe(failed, s)
+		b.sendMessage(failed, s)
 		return
 	}
 
@@ -535,36 +630,35 @@ func (b *bot) provideAltAngelthumpLink(m dggchat.Message, s *dggchat.Session) {
 	server := strings.TrimSpace(parts[2])
 	srv, ok := servers[strings.ToLower(server)]
 	if !ok {
-		log.Printf("[##] invalid server: %s is not a valid Angelthump server", server)
-		b.sendMessageDedupe(failed, s)
+		eventLog.warnf("invalid server: %s is not a valid Angelthump server", server)
+		b.sendMessage(failed, s)
 		return
 	}
 
-	atd, err := b.getATUserData(username)
+	atd, err := b.at.getATUserData(context.Background(), username)
 	if err != nil {
-		log.Printf("[##] checkAT error1: '%s'\n",
+		eventLog.errorf("checkAT error1: '%s'\n",
 			err.Error())
 
-		// workaround... depends on content of error message
-		if strings.Contains(err.Error(), "404") {
-			log.Printf("[##] check: not found\n")
+		if errors.Is(err, errATUserNotFound) {
+			eventLog.debugf("check: not found\n")
 			return
 		}
 
-		b.sendMessageDedupe("error getting api data", s)
+		b.sendMessage("error getting api data", s)
 		return
 	}
 
 	if atd.User.Username == "" {
-		log.Printf("[##] unable to find %s's AT username: %+v", username, atd)
-		b.sendMessageDedupe("could not locate the streamer's AngelThump username", s)
+		eventLog.warnf("unable to find %s's AT username: %+v", username, atd)
+		b.sendMessage("could not locate the streamer's AngelThump username", s)
 		return
 	}
 
 	token := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s%s", atd.UpdatedAt.Format(time.RFC3339Nano), strings.ToLower(atd.User.Username))))
 	m3u8 := fmt.Sprintf("https://%s.angelthump.com/hls/%s_%s/index.m3u8", srv, token, strings.ToLower(atd.User.Username))
 	output := fmt.Sprintf("https://strims.gg/m3u8/%s", m3u8)
-	b.sendMessageDedupe(output, s)
+	b.sendMessage(output, s)
 }
 
 // https://gist.github.com/harshavardhana/327e0577c4fed9211f65
@@ -601,11 +695,7 @@ func humanizeDuration(duration time.Duration) string {
 }
 
 // !(un)ban -- ban a user
-func (b *bot) ban(m dggchat.Message, s *dggchat.Session) {
-	if !isMod(m.Sender) || (!strings.HasPrefix(m.Message, "!ban") && !strings.HasPrefix(m.Message, "!unban")) {
-		return
-	}
-
+func (b *bot) ban(m dggchat.Message, s chatSession) {
 	parts := strings.Split(m.Message, " ")
 	if len(parts) < 2 {
 		return
@@ -616,8 +706,12 @@ func (b *bot) ban(m dggchat.Message, s *dggchat.Session) {
 		if len(parts) == 3 {
 			reason = parts[2]
 		}
+		b.recordOffence(parts[1], reason, fmt.Sprintf("!ban by %s", m.Sender.Nick))
 		s.SendBan(parts[1], reason, 0, false)
+		b.audit(auditEntry{Action: "ban", Actor: m.Sender.Nick, Target: parts[1],
+			Reason: reason, Origin: b.origin})
 	} else if parts[0] == "!unban" {
 		s.SendUnban(parts[1])
+		b.audit(auditEntry{Action: "unban", Actor: m.Sender.Nick, Target: parts[1], Origin: b.origin})
 	}
 }
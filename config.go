@@ -0,0 +1,327 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/MemeLabs/dggchat"
+)
+
+const (
+	defaultNukeDuration       = 10 * time.Minute
+	defaultNukeWindow         = 5 * time.Minute
+	defaultRaidConfirmTimeout = 5 * time.Minute
+)
+
+// config holds all settings. They are read from the config file, the
+// environment for secrets and flags, in increasing priority.
+type config struct {
+	Cookie  string `json:"cookie"`
+	ChatURL string `json:"chat"`
+	APIURL  string `json:"api"`
+	ATToken string `json:"attoken"`
+	// CookieFile and ATTokenFile are read on every load and override Cookie
+	// and ATToken, e.g. for docker secrets.
+	CookieFile    string   `json:"cookie_file"`
+	ATTokenFile   string   `json:"attoken_file"`
+	Log           string   `json:"log"`
+	Logging       string   `json:"logging"`
+	Commands      string   `json:"commands"`
+	Rules         string   `json:"rules"`
+	Roles         string   `json:"roles"`
+	Audit         string   `json:"audit"`
+	State         string   `json:"state"`
+	LogOnly       bool     `json:"logonly"`
+	MaxReconnects int      `json:"max_reconnects"`
+	MetricsAddr   string   `json:"metrics_addr"`
+	MaxSilence    duration `json:"max_silence"`
+	AuthCheck     duration `json:"auth_check"`
+
+	WebsiteURL   string `json:"website_url"`
+	OminousEmote string `json:"ominous_emote"`
+	// PollTime is how often the rules file is checked for changes.
+	PollTime     duration `json:"poll_time"`
+	NukeDuration duration `json:"nuke_duration"`
+	NukeWindow   duration `json:"nuke_window"`
+	// RaidConfirmTimeout is how long raid alerts can be confirmed with !raid.
+	RaidConfirmTimeout duration `json:"raid_confirm_timeout"`
+	// ATServers maps the names !alt accepts to angelthump hosts.
+	ATServers map[string]string `json:"angelthump_servers"`
+}
+
+func defaultConfig() *config {
+	return &config{
+		ChatURL:            "wss://chat.strims.gg/ws",
+		APIURL:             "https://strims.gg/api",
+		Log:                "/tmp/chatlog/chatlog.log",
+		Logging:            "logging.json",
+		Commands:           "commands.json",
+		Rules:              "rules.json",
+		Roles:              "roles.json",
+		MaxReconnects:      10,
+		AuthCheck:          duration{5 * time.Minute},
+		WebsiteURL:         "strims.gg",
+		OminousEmote:       "BOGGED",
+		PollTime:           duration{2 * time.Second},
+		NukeDuration:       duration{defaultNukeDuration},
+		NukeWindow:         duration{defaultNukeWindow},
+		RaidConfirmTimeout: duration{defaultRaidConfirmTimeout},
+		ATServers: map[string]string{
+			"nyc": "nyc-haproxy",
+			"sfo": "sfo-haproxy",
+			"sgp": "sgp-haproxy",
+			"lon": "lon-haproxy",
+			"fra": "fra-haproxy",
+			"blr": "blr-haproxy",
+			"ams": "ams-haproxy",
+			"tor": "tor-haproxy",
+		},
+	}
+}
+
+// configEnv are the environment variables overriding secrets.
+var configEnv = map[string]func(c *config) *string{
+	"MODBOT_COOKIE":  func(c *config) *string { return &c.Cookie },
+	"MODBOT_ATTOKEN": func(c *config) *string { return &c.ATToken },
+}
+
+// options are flags which aren't settings.
+type options struct {
+	config      string
+	replay      string
+	replayMods  string
+	dumpHelp    bool
+	healthcheck string
+}
+
+// registerFlags registers all flags on fs, defaulting to the values in c.
+func registerFlags(fs *flag.FlagSet, c *config, o *options) {
+	fs.StringVar(&o.config, "config", "modbot.json", "config file, reloaded on SIGHUP and !reload (optional)")
+	fs.StringVar(&c.Cookie, "cookie", c.Cookie, "Cookie used for chat authentication and API access, or $MODBOT_COOKIE")
+	fs.String("path", "", "unused, kept for compatibility")
+	fs.StringVar(&c.ChatURL, "chat", c.ChatURL, "ws(s)-url for chat")
+	fs.StringVar(&c.APIURL, "api", c.APIURL, "basic backend api path")
+	fs.StringVar(&c.Log, "log", c.Log, "file to write messages and events to, unless set in -logging")
+	fs.StringVar(&c.Logging, "logging", c.Logging, "log sinks, formats, levels and rotation (optional)")
+	fs.StringVar(&c.Commands, "commands", c.Commands, "static commands file")
+	fs.StringVar(&c.ATToken, "attoken", c.ATToken, "angelthump admin token, or $MODBOT_ATTOKEN (optional)")
+	fs.StringVar(&c.CookieFile, "cookie-file", c.CookieFile, "file to read the cookie from, overrides -cookie, reread on reload (optional)")
+	fs.StringVar(&c.ATTokenFile, "attoken-file", c.ATTokenFile, "file to read the angelthump admin token from, overrides -attoken, reread on reload (optional)")
+	fs.BoolVar(&c.LogOnly, "logonly", c.LogOnly, "only 'reply' to logfile, not chat (for debugging)")
+	fs.StringVar(&c.Rules, "rules", c.Rules, "spam rules file, reloaded on change")
+	fs.StringVar(&c.Roles, "roles", c.Roles, "role mapping and allowlist file, edited with !perms")
+	fs.StringVar(&c.Audit, "audit", c.Audit, "JSONL file to append moderation actions to (optional)")
+	fs.StringVar(&c.State, "state", c.State, "directory to persist moderation state in (optional)")
+	fs.StringVar(&o.replay, "replay", "", "replay a chat log or JSONL file offline and print the bot's actions")
+	fs.StringVar(&o.replayMods, "replay-mods", "", "comma separated nicks treated as mods during -replay")
+	fs.IntVar(&c.MaxReconnects, "max-reconnects", c.MaxReconnects, "exit after this many failed reconnects in a row, 0 retries forever")
+	fs.StringVar(&c.MetricsAddr, "metrics-addr", c.MetricsAddr, "address to serve prometheus metrics and health checks on, e.g. :9100 (optional)")
+	fs.DurationVar(&c.MaxSilence.Duration, "max-silence", c.MaxSilence.Duration, "report not ready if no message was received for this long, 0 disables")
+	fs.DurationVar(&c.AuthCheck.Duration, "auth-check", c.AuthCheck.Duration, "interval to check that the cookie is still valid, 0 disables")
+	fs.StringVar(&o.healthcheck, "healthcheck", "", "request this health check url, exit with 0 if it's ok and 1 otherwise")
+	fs.BoolVar(&o.dumpHelp, "dump-help", false, "print the markdown command table and exit")
+}
+
+// readConfig reads the config file at path, a missing file leaves the
+// defaults. The environment and the flags in args override it.
+func readConfig(path string, args []string) (*config, error) {
+	c := defaultConfig()
+	data, err := ioutil.ReadFile(path)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return nil, err
+	default:
+		// maps would merge into the defaults, the file replaces them instead.
+		c.ATServers = nil
+		if err := json.Unmarshal(data, c); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		if len(c.ATServers) == 0 {
+			c.ATServers = defaultConfig().ATServers
+		}
+	}
+
+	for name, field := range configEnv {
+		if v, ok := os.LookupEnv(name); ok {
+			*field(c) = v
+		}
+	}
+
+	fs := flag.NewFlagSet("modbot", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	registerFlags(fs, c, &options{})
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	for _, secret := range []struct{ path, value *string }{
+		{&c.CookieFile, &c.Cookie},
+		{&c.ATTokenFile, &c.ATToken},
+	} {
+		if *secret.path == "" {
+			continue
+		}
+		data, err := ioutil.ReadFile(*secret.path)
+		if err != nil {
+			return nil, err
+		}
+		*secret.value = strings.TrimSpace(string(data))
+	}
+	return c, c.validate()
+}
+
+func (c *config) validate() error {
+	if _, err := url.Parse(c.ChatURL); err != nil {
+		return fmt.Errorf("chat: %v", err)
+	}
+	for name, d := range map[string]time.Duration{
+		"nuke_duration":        c.NukeDuration.Duration,
+		"nuke_window":          c.NukeWindow.Duration,
+		"poll_time":            c.PollTime.Duration,
+		"raid_confirm_timeout": c.RaidConfirmTimeout.Duration,
+	} {
+		if d <= 0 {
+			return fmt.Errorf("%s has to be positive", name)
+		}
+	}
+	if len(c.ATServers) == 0 {
+		return fmt.Errorf("angelthump_servers must not be empty")
+	}
+	return nil
+}
+
+// restartOnly lists settings which changed between c and next but only
+// take effect after a restart.
+func (c *config) restartOnly(next *config) []string {
+	changed := []string{}
+	for name, differs := range map[string]bool{
+		"audit":        c.Audit != next.Audit,
+		"state":        c.State != next.State,
+		"metrics_addr": c.MetricsAddr != next.MetricsAddr,
+		"auth_check":   c.AuthCheck != next.AuthCheck,
+	} {
+		if differs {
+			changed = append(changed, name)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// applyConfig loads the files next names and switches to them and its
+// settings. Nothing changes if a file fails to load. The caller holds b.mu.
+func (b *bot) applyConfig(next *config) error {
+	cmds, err := loadStaticCommands(next.Commands)
+	if err != nil {
+		return fmt.Errorf("commands: %v", err)
+	}
+
+	ruleCfg, err := loadRules(next.Rules)
+	switch {
+	case os.IsNotExist(err):
+		eventLog.infof("no rules file %s, using default rules\n", next.Rules)
+		ruleCfg = &ruleConfig{Rules: defaultRules(), Raid: defaultRaidConfig(), Ladder: defaultLadderConfig()}
+	case err != nil:
+		return fmt.Errorf("rules: %v", err)
+	}
+
+	roleCfg := defaultRoleConfig()
+	if next.Roles != "" {
+		roleCfg, err = loadRoles(next.Roles)
+		switch {
+		case os.IsNotExist(err):
+			eventLog.infof("no roles file %s, using default roles\n", next.Roles)
+			roleCfg = defaultRoleConfig()
+		case err != nil:
+			return fmt.Errorf("roles: %v", err)
+		}
+	}
+
+	// replay and tests leave the loggers alone.
+	var logs logFiles
+	if b.logs != nil {
+		logCfg, err := loadLogConfig(next.Logging, next.Log)
+		switch {
+		case os.IsNotExist(err):
+			logCfg = defaultLogConfig(next.Log)
+		case err != nil:
+			return fmt.Errorf("logging: %v", err)
+		}
+		if logs, err = setupLogging(logCfg); err != nil {
+			return fmt.Errorf("logging: %v", err)
+		}
+		b.logs.close()
+		b.logs = logs
+	}
+
+	if shadowed := b.shadowedCommands(cmds); len(shadowed) > 0 {
+		eventLog.warnf("commands: built-in commands take precedence over %s\n", strings.Join(shadowed, ", "))
+	}
+	mutex.Lock()
+	commands = cmds
+	mutex.Unlock()
+	b.rules = ruleCfg.Rules
+	b.raid = ruleCfg.Raid
+	b.ladder = ruleCfg.Ladder
+	b.roles = roleCfg
+	b.strims = newStrimsClient(next.APIURL, next.Cookie)
+	b.at = newAngelthumpClient(angelthumpAPIURL, next.ATToken)
+	b.health.setMaxSilence(next.MaxSilence.Duration)
+	if b.conn != nil {
+		u, _ := url.Parse(next.ChatURL) // checked by validate
+		b.conn.configure(next.Cookie, *u, next.MaxReconnects)
+	}
+	b.cfg = next
+	return nil
+}
+
+// reload reads the config again and applies it. Settings which only take
+// effect after a restart keep their old values and are returned. The caller
+// holds b.mu.
+func (b *bot) reload() ([]string, error) {
+	if b.readConfig == nil {
+		return nil, fmt.Errorf("reloading is disabled")
+	}
+	next, err := b.readConfig()
+	if err == nil {
+		pending := b.cfg.restartOnly(next)
+		next.Audit, next.State, next.MetricsAddr, next.AuthCheck = b.cfg.Audit, b.cfg.State, b.cfg.MetricsAddr, b.cfg.AuthCheck
+		if err = b.applyConfig(next); err == nil {
+			eventLog.infof("config: reloaded\n")
+			if len(pending) > 0 {
+				eventLog.warnf("config: restart to apply %s\n", strings.Join(pending, ", "))
+			}
+			// the cookie or token may have changed, the checks need b.mu.
+			go b.preflight()
+			return pending, nil
+		}
+	}
+
+	eventLog.errorf("config: keeping old config, reload failed: %s\n", err.Error())
+	// still pick up log files moved by logrotate.
+	if err := b.logs.reopen(); err != nil {
+		eventLog.errorf("error reopening logs: %s\n", err.Error())
+	}
+	return nil, err
+}
+
+// !reload - read the config file and the files it names again.
+func (b *bot) reloadCommand(m dggchat.Message, s chatSession) {
+	pending, err := b.reload()
+	switch {
+	case err != nil:
+		b.sendMessage("reload failed, keeping the old config: "+err.Error(), s)
+	case len(pending) > 0:
+		b.sendMessage("reloaded, restart to apply "+strings.Join(pending, ", "), s)
+	default:
+		b.sendMessage("reloaded config", s)
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "modbot-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "modbot.json")
+	data := `{"cookie": "from-file", "chat": "wss://file/ws", "api": "https://file/api", "nuke_window": "1m"}`
+	if err := ioutil.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("MODBOT_COOKIE", "from-env")
+	defer os.Unsetenv("MODBOT_COOKIE")
+
+	c, err := readConfig(path, []string{"-config", path, "-api", "https://flag/api"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Cookie != "from-env" || c.ChatURL != "wss://file/ws" || c.APIURL != "https://flag/api" {
+		t.Errorf("expected env over file and flags over both, got %+v", c)
+	}
+	if c.NukeWindow.Duration != time.Minute || c.NukeDuration.Duration != defaultNukeDuration {
+		t.Errorf("expected file durations over defaults, got %s and %s", c.NukeWindow, c.NukeDuration)
+	}
+
+	if _, err := readConfig("modbot.json.example", nil); err != nil {
+		t.Errorf("the example config should work as is, got %v", err)
+	}
+	if _, err := readConfig(filepath.Join(dir, "missing.json"), nil); err != nil {
+		t.Errorf("a missing config file should leave the defaults, got %v", err)
+	}
+	ioutil.WriteFile(path, []byte(`{"angelthump_servers": {"nyc": "nyc-haproxy"}}`), 0o644)
+	c, err = readConfig(path, nil)
+	if err != nil || len(c.ATServers) != 1 || c.ATServers["nyc"] != "nyc-haproxy" {
+		t.Errorf("expected the servers from the file to replace the defaults, got %v, %v", c, err)
+	}
+	ioutil.WriteFile(path, []byte(`{"nuke_window": "1m"}`), 0o644)
+	if c, err := readConfig(path, nil); err != nil || len(c.ATServers) != len(defaultConfig().ATServers) {
+		t.Errorf("expected the default servers without the setting, got %v, %v", c, err)
+	}
+	ioutil.WriteFile(path, []byte(`{"nuke_window": "-1m"}`), 0o644)
+	if _, err := readConfig(path, nil); err == nil {
+		t.Error("expected a negative nuke window to be rejected")
+	}
+}
+
+func TestReadConfigSecretFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "modbot-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cookie := filepath.Join(dir, "cookie")
+	ioutil.WriteFile(cookie, []byte("jwt-from-file\n"), 0o600)
+	path := filepath.Join(dir, "modbot.json")
+	f := &fakeBackend{status: http.StatusOK, body: `{"username":"bot","is_admin":true}`}
+	api := f.start(t).URL
+	data := `{"cookie_file": "` + cookie + `", "api": "` + api + `", "commands": "` + filepath.Join(dir, "commands.json") + `", "roles": ""}`
+	ioutil.WriteFile(path, []byte(data), 0o644)
+	oldCommands := commands
+	defer func() { commands = oldCommands }()
+
+	c, err := readConfig(path, []string{"-cookie", "from-flag"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Cookie != "jwt-from-file" {
+		t.Errorf("expected the trimmed cookie from the file, got %q", c.Cookie)
+	}
+
+	b, s := newTestBot()
+	b.prereqs = map[prereq]error{prereqAuth: errors.New("expired"), prereqAdmin: errors.New("not logged in")}
+	b.readConfig = func() (*config, error) { return readConfig(path, nil) }
+	ioutil.WriteFile(cookie, []byte("rotated-jwt"), 0o600)
+	say(b, s, testMod, "!reload")
+	expectMessage(t, s, "reloaded config")
+	if b.cfg.Cookie != "rotated-jwt" || b.strims.authCookie != "rotated-jwt" {
+		t.Errorf("expected the rotated cookie to be used, got %q and %q", b.cfg.Cookie, b.strims.authCookie)
+	}
+
+	// the checks run again in the background.
+	for start := time.Now(); ; time.Sleep(time.Millisecond) {
+		b.mu.Lock()
+		err := b.prereqs[prereqAdmin]
+		b.mu.Unlock()
+		if err == nil {
+			break
+		}
+		if time.Since(start) > time.Second {
+			t.Fatalf("expected the checks to pass after the reload, got %v", err)
+		}
+	}
+	if f.header.Get("Cookie") != authCookieName+"=rotated-jwt" {
+		t.Errorf("expected the rotated cookie to be checked, got %q", f.header.Get("Cookie"))
+	}
+
+	os.Remove(cookie)
+	if _, err := readConfig(path, nil); err == nil {
+		t.Error("expected a missing cookie file to fail")
+	}
+}
+
+func TestReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "modbot-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	oldCommands := commands
+	defer func() { commands = oldCommands }()
+
+	path := filepath.Join(dir, "modbot.json")
+	write := func(name, data string) {
+		t.Helper()
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("commands.json", `{"!test": "reloaded"}`)
+	write("rules.json", `{"rules": [{"name": "caps", "type": "caps", "limit": 0.8, "min_length": 10, "count": 1, "history": 1, "window": "1m", "action": "mute"}]}`)
+	write("modbot.json", `{
+		"commands": "`+filepath.Join(dir, "commands.json")+`",
+		"rules": "`+filepath.Join(dir, "rules.json")+`",
+		"roles": "",
+		"api": "",
+		"ominous_emote": "DOOMED",
+		"audit": "modlog.jsonl"
+	}`)
+
+	b, s := newTestBot()
+	b.readConfig = func() (*config, error) { return readConfig(path, nil) }
+
+	say(b, s, testUser, "!reload")
+	if len(s.actions) != 0 {
+		t.Fatalf("!reload is for mods only, got %v", s.actions)
+	}
+	say(b, s, testMod, "!reload")
+	expectMessage(t, s, "reloaded, restart to apply audit")
+	s.take()
+	if len(b.rules) != 1 || b.rules[0].Name != "caps" || b.cfg.OminousEmote != "DOOMED" {
+		t.Errorf("expected the new rules and settings, got %v and %+v", b.rules, b.cfg)
+	}
+	if b.cfg.Audit != "" {
+		t.Errorf("audit only changes on restart, got %q", b.cfg.Audit)
+	}
+	say(b, s, testUser, "!test")
+	expectMessage(t, s, "reloaded")
+	s.take()
+
+	write("rules.json", `{"rules": [{"name": "broken", "type": "nope", "count": 1, "action": "mute"}]}`)
+	say(b, s, testMod, "!reload")
+	expectMessage(t, s, "reload failed, keeping the old config: rules:")
+	if len(b.rules) != 1 || b.rules[0].Name != "caps" {
+		t.Errorf("expected the old rules to be kept, got %v", b.rules)
+	}
+}
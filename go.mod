@@ -1,5 +1,8 @@
 module github.com/MemeLabs/modbot
 
-require github.com/MemeLabs/dggchat v0.0.0-20201117114323-43344edb4906
+require (
+	github.com/MemeLabs/dggchat v0.0.0-20201117114323-43344edb4906
+	github.com/gorilla/websocket v1.4.2
+)
 
 go 1.13
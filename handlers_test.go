@@ -0,0 +1,293 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MemeLabs/dggchat"
+)
+
+var (
+	testAdmin = dggchat.User{Nick: "admin", Features: []string{"admin"}}
+	testMod   = dggchat.User{Nick: "mod", Features: []string{"moderator"}}
+	testUser  = dggchat.User{Nick: "user"}
+)
+
+func newTestBot() (*bot, *recordingSession) {
+	b := newBot(250)
+	// keep commands and grants away from the files in the working directory.
+	b.cfg.Commands = os.DevNull
+	b.cfg.Roles = ""
+	b.registerDefaults()
+	return b, &recordingSession{users: []dggchat.User{testMod, testUser}}
+}
+
+func say(b *bot, s chatSession, user dggchat.User, msg string) {
+	b.handleMessage(dggchat.Message{Sender: user, Message: msg, Timestamp: time.Now()}, s)
+}
+
+func whisper(b *bot, s chatSession, user dggchat.User, msg string) {
+	b.handlePM(dggchat.PrivateMessage{User: user, Message: msg, Timestamp: time.Now()}, s)
+}
+
+func chatter(nick string) dggchat.User {
+	return dggchat.User{Nick: nick}
+}
+
+// nicks returns the nicks of all actions.
+func nicks(actions []action) []string {
+	out := []string{}
+	for _, a := range actions {
+		out = append(out, a.Nick)
+	}
+	return out
+}
+
+func expectNicks(t *testing.T, what string, actions []action, want ...string) {
+	t.Helper()
+	got := nicks(actions)
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("%s: got %v, want %v", what, got, want)
+	}
+}
+
+func expectMessage(t *testing.T, s *recordingSession, prefix string) {
+	t.Helper()
+	for _, a := range s.find("MSG") {
+		if strings.HasPrefix(a.Message, prefix) {
+			return
+		}
+	}
+	t.Errorf("expected a message starting with %q, got %v", prefix, s.actions)
+}
+
+func TestNukeAndAegis(t *testing.T) {
+	b, s := newTestBot()
+	say(b, s, chatter("a"), "this is a badword")
+	say(b, s, chatter("b"), "nothing to see")
+	say(b, s, chatter("a"), "badword again")
+	say(b, s, chatter("c"), "BADWORD badword")
+	say(b, s, testMod, "!nuke 1h badword")
+
+	mutes := s.find("MUTE")
+	expectNicks(t, "nuke", mutes, "a", "c")
+	for _, m := range mutes {
+		if m.Duration != time.Hour {
+			t.Errorf("expected 1h mute, got %s", m.Duration)
+		}
+	}
+	expectMessage(t, s, "nuked 2 users for 1hour")
+	expectNicks(t, "victim list", s.find("PRIVMSG"), "mod")
+	s.take()
+
+	// the nuke stays armed.
+	say(b, s, chatter("d"), "late badword")
+	say(b, s, chatter("e"), "fine")
+	expectNicks(t, "armed nuke", s.find("MUTE"), "d")
+	s.take()
+
+	say(b, s, testMod, "!aegis")
+	expectNicks(t, "aegis", s.find("UNMUTE"), "a", "c", "d")
+	s.take()
+
+	say(b, s, testMod, "!aegis")
+	expectNicks(t, "empty aegis", s.find("UNMUTE"))
+}
+
+func TestNukeViaPM(t *testing.T) {
+	b, s := newTestBot()
+	say(b, s, chatter("a"), "badword")
+	whisper(b, s, testMod, "!nuke badword")
+	expectNicks(t, "nuke via PM", s.find("MUTE"), "a")
+	// the announcement and victim list go to the issuer.
+	expectNicks(t, "PM replies", s.find("PRIVMSG"), "mod", "mod")
+	if msgs := s.find("MSG"); len(msgs) != 0 {
+		t.Errorf("expected no chat messages, got %v", msgs)
+	}
+	s.take()
+
+	say(b, s, chatter("b"), "otherword")
+	whisper(b, s, testMod, "!nuke otherword --public")
+	expectNicks(t, "public nuke via PM", s.find("MUTE"), "b")
+	expectMessage(t, s, "nuked 1 user for 10mins")
+
+	whisper(b, s, testMod, "!nukes")
+	for _, pm := range s.find("PRIVMSG") {
+		if strings.Contains(pm.Message, "--public") {
+			t.Errorf("the suffix shouldn't be part of the nuke: %q", pm.Message)
+		}
+	}
+}
+
+func TestSayViaPM(t *testing.T) {
+	b, s := newTestBot()
+	whisper(b, s, testAdmin, "!say hi chat")
+	expectMessage(t, s, "hi chat")
+	if pms := s.find("PRIVMSG"); len(pms) != 0 {
+		t.Errorf("!say should post to chat, got %v", pms)
+	}
+}
+
+func TestNukeRegexpError(t *testing.T) {
+	b, s := newTestBot()
+	say(b, s, testMod, "!nukeregex (broken")
+	if pms := s.find("PRIVMSG"); len(pms) != 1 || !strings.HasPrefix(pms[0].Message, "regexp error: ") {
+		t.Errorf("expected the error via PM, got %v", pms)
+	}
+	if msgs := s.find("MSG"); len(msgs) != 0 {
+		t.Errorf("expected nothing in chat, got %v", msgs)
+	}
+}
+
+func TestNukeNotForUsers(t *testing.T) {
+	b, s := newTestBot()
+	say(b, s, chatter("a"), "badword")
+	say(b, s, testUser, "!nuke badword")
+	whisper(b, s, testUser, "!nuke badword")
+	if len(s.actions) != 0 {
+		t.Errorf("expected no actions, got %v", s.actions)
+	}
+}
+
+func TestAegisSingleAndAll(t *testing.T) {
+	b, s := newTestBot()
+	say(b, s, chatter("a"), "first")
+	say(b, s, testMod, "!nuke first")
+	say(b, s, chatter("b"), "second")
+	say(b, s, testMod, "!nuke second")
+	say(b, s, chatter("c"), "third")
+	say(b, s, testMod, "!nukeregex th.rd")
+	s.take()
+
+	b.recordOffence("a", "spam", "!mute by mod")
+	say(b, s, testMod, "!aegis 1")
+	expectNicks(t, "aegis 1", s.find("UNMUTE"), "a")
+	s.take()
+	// only the nuke's offence is forgotten.
+	if o := b.activeOffences("a"); len(o) != 1 || o[0].Source != "!mute by mod" {
+		t.Errorf("expected the nuke's offence to be undone, got %v", o)
+	}
+
+	say(b, s, testMod, "!aegis 1")
+	expectNicks(t, "aegis of undone nuke", s.find("UNMUTE"))
+	s.take()
+
+	say(b, s, testMod, "!aegisall")
+	expectNicks(t, "aegisall", s.find("UNMUTE"), "b", "c")
+	if len(b.offences) != 1 {
+		t.Errorf("expected only the manual offence to be left, got %v", b.offences)
+	}
+}
+
+func TestMute(t *testing.T) {
+	b, s := newTestBot()
+	say(b, s, testMod, "!mute user 5m")
+	say(b, s, testMod, "!mute user")
+	say(b, s, testMod, "!mute user")
+	say(b, s, testMod, "!muted user")
+
+	mutes := s.find("MUTE")
+	expectNicks(t, "mute", mutes, "user", "user", "user")
+	// explicit durations are kept, the ladder escalates the others.
+	want := []time.Duration{5 * time.Minute, 30 * time.Minute, 2 * time.Hour}
+	for i, m := range mutes {
+		if m.Duration != want[i] {
+			t.Errorf("mute %d: got %s, want %s", i+1, m.Duration, want[i])
+		}
+	}
+
+	say(b, s, testMod, "!unmute user")
+	expectNicks(t, "unmute", s.find("UNMUTE"), "user")
+}
+
+func TestBan(t *testing.T) {
+	b, s := newTestBot()
+	say(b, s, testUser, "!ban mod")
+	say(b, s, testMod, "!ban user spam")
+	say(b, s, testMod, "!banana user")
+
+	bans := s.find("BAN")
+	expectNicks(t, "ban", bans, "user")
+	if len(bans) == 1 && bans[0].Message != "spam" {
+		t.Errorf("expected ban reason 'spam', got %q", bans[0].Message)
+	}
+
+	say(b, s, testMod, "!unban user")
+	expectNicks(t, "unban", s.find("UNBAN"), "user")
+
+	if n := len(b.activeOffences("user")); n != 1 {
+		t.Errorf("expected the ban to be recorded as offence, got %d", n)
+	}
+}
+
+func TestAddCommand(t *testing.T) {
+	dir, err := ioutil.TempDir("", "modbot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	oldCommands := commands
+	defer func() { commands = oldCommands }()
+	commands = map[string]string{}
+
+	b, s := newTestBot()
+	b.cfg.Commands = filepath.Join(dir, "commands.json")
+	say(b, s, testMod, "!addcommand test i like tests")
+	if len(s.actions) != 0 {
+		t.Fatalf("!addcommand is for admins only, got %v", s.actions)
+	}
+
+	say(b, s, testAdmin, "!addcommand test i like tests")
+	expectMessage(t, s, "added new command !test")
+	s.take()
+
+	say(b, s, testUser, "!test")
+	expectMessage(t, s, "i like tests")
+	s.take()
+
+	saved, err := ioutil.ReadFile(b.cfg.Commands)
+	if err != nil || !strings.Contains(string(saved), "i like tests") {
+		t.Errorf("expected command to be saved, got %q, %v", saved, err)
+	}
+
+	say(b, s, testAdmin, "!addcommand !test _")
+	expectMessage(t, s, "deleted")
+	s.take()
+
+	say(b, s, testUser, "!test")
+	if len(s.actions) != 0 {
+		t.Errorf("expected deleted command to be gone, got %v", s.actions)
+	}
+}
+
+func TestShortMessageRule(t *testing.T) {
+	b, s := newTestBot()
+	for i := 0; i < 4; i++ {
+		say(b, s, testUser, "a")
+	}
+	if len(s.actions) != 0 {
+		t.Fatalf("expected no action yet, got %v", s.actions)
+	}
+
+	say(b, s, testUser, "a")
+	expectNicks(t, "short messages", s.find("MUTE"), "user")
+	expectMessage(t, s, "user - too many short messages")
+}
+
+func TestRuleBan(t *testing.T) {
+	b, s := newTestBot()
+	r := &rule{Name: "links", Type: "links", Limit: 1, Count: 1, Action: "ban", Reason: "no links"}
+	if err := r.compile(); err != nil {
+		t.Fatal(err)
+	}
+	b.rules = []*rule{r}
+
+	say(b, s, testMod, "https://example.com")
+	say(b, s, testUser, "https://example.com")
+	expectNicks(t, "link ban", s.find("BAN"), "user")
+}
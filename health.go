@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// maxHealthyDowntime is how long reconnecting may take before the bot
+	// counts as dead.
+	maxHealthyDowntime = 5 * time.Minute
+	authCheckTimeout   = 10 * time.Second
+)
+
+// health tracks what the health and readiness checks report.
+type health struct {
+	// now is a var so tests can move time.
+	now func() time.Time
+
+	mu sync.Mutex
+	// maxSilence is how long the chat may be quiet before the bot counts as
+	// not ready, 0 disables the check. A quiet chat isn't a reason to restart.
+	maxSilence  time.Duration
+	started     time.Time
+	conn        *chatConn
+	lastMessage time.Time
+	authChecked time.Time
+	authErr     error
+}
+
+func newHealth(maxSilence time.Duration) *health {
+	return &health{maxSilence: maxSilence, now: time.Now, started: time.Now()}
+}
+
+func (h *health) setMaxSilence(d time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.maxSilence = d
+}
+
+// setConn sets the connection once the bot connected.
+func (h *health) setConn(c *chatConn) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.conn = c
+}
+
+// sawMessage is called for every message and PM received.
+func (h *health) sawMessage() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.lastMessage = h.now()
+}
+
+func (h *health) setAuth(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.authChecked, h.authErr = h.now(), err
+}
+
+// healthStatus is the body of /healthz and /readyz.
+type healthStatus struct {
+	// Live is false if the bot should be restarted.
+	Live bool `json:"live"`
+	// Ready is true if the bot is connected and logged in.
+	Ready     bool   `json:"ready"`
+	Connected bool   `json:"connected"`
+	DownFor   string `json:"down_for,omitempty"`
+	// SinceLastMessage is since the start if no message was received yet.
+	SinceLastMessage string   `json:"since_last_message"`
+	AuthChecked      string   `json:"auth_checked,omitempty"`
+	AuthError        string   `json:"auth_error,omitempty"`
+	Problems         []string `json:"problems,omitempty"`
+}
+
+func (h *health) status() healthStatus {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := h.now()
+	st := healthStatus{Live: true, Problems: []string{}}
+
+	// before the first connect the start is the beginning of the outage.
+	downSince := h.started
+	if h.conn != nil {
+		cs := h.conn.stats()
+		st.Connected = cs.connected
+		downSince = cs.downSince
+	}
+	if !st.Connected {
+		down := now.Sub(downSince)
+		st.DownFor = down.Truncate(time.Second).String()
+		st.Problems = append(st.Problems, "not connected to chat")
+		if down > maxHealthyDowntime {
+			st.Live = false
+		}
+	}
+
+	lastMessage := h.lastMessage
+	if lastMessage.IsZero() {
+		lastMessage = h.started
+	}
+	silence := now.Sub(lastMessage)
+	st.SinceLastMessage = silence.Truncate(time.Second).String()
+	silent := h.maxSilence > 0 && silence > h.maxSilence
+	if silent {
+		st.Problems = append(st.Problems, "no messages received for "+formatDuration(silence.Truncate(time.Second)))
+	}
+
+	switch {
+	case h.authChecked.IsZero():
+		st.Problems = append(st.Problems, "auth not checked yet")
+	case h.authErr != nil:
+		st.AuthError = h.authErr.Error()
+		st.Problems = append(st.Problems, "auth check failed")
+	}
+	if !h.authChecked.IsZero() {
+		st.AuthChecked = h.authChecked.Format(time.RFC3339)
+	}
+
+	st.Ready = st.Connected && !silent && !h.authChecked.IsZero() && h.authErr == nil
+	return st
+}
+
+// handler serves the status as JSON, with status code 503 unless ok says
+// the bot is fine.
+func (h *health) handler(ok func(healthStatus) bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		st := h.status()
+		w.Header().Set("Content-Type", "application/json")
+		if !ok(st) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(st)
+	})
+}
+
+// checkAuth checks whether the bot's cookie is still accepted by the
+// backend and learns the bot's nick from it.
+func (b *bot) checkAuth() (userInfo, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), authCheckTimeout)
+	defer cancel()
+	b.mu.Lock()
+	strims := b.strims
+	b.mu.Unlock()
+	info, err := strims.getProfileInfo(ctx)
+	b.health.setAuth(err)
+	if err != nil {
+		return userInfo{}, err
+	}
+	b.mu.Lock()
+	b.nick = info.Username
+	b.mu.Unlock()
+	return info, nil
+}
+
+// watchAuth checks the auth every interval until the process exits, commands
+// needing it are disabled while it fails.
+func (b *bot) watchAuth(interval time.Duration) {
+	for range time.Tick(interval) {
+		b.recheckAuth()
+	}
+}
+
+// recheckAuth checks the auth again and updates the prerequisites.
+func (b *bot) recheckAuth() {
+	results := b.checkAuthPrereqs()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.setPrereq(prereqAuth, results[prereqAuth])
+	b.setPrereq(prereqAdmin, results[prereqAdmin])
+}
+
+// checkHealth requests url and returns an error unless it answers with 200,
+// for container health checks in images without curl.
+func checkHealth(url string) error {
+	client := http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return &apiError{StatusCode: resp.StatusCode}
+	}
+	return nil
+}
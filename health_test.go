@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/MemeLabs/dggchat"
+)
+
+func TestHealthStatus(t *testing.T) {
+	h := newHealth(time.Hour)
+	now := h.started
+	h.now = func() time.Time { return now }
+
+	// connecting
+	st := h.status()
+	if !st.Live || st.Ready || st.Connected {
+		t.Errorf("expected live but not ready while connecting, got %+v", st)
+	}
+	now = now.Add(maxHealthyDowntime + time.Second)
+	if st := h.status(); st.Live {
+		t.Errorf("expected dead after %s without connection, got %+v", maxHealthyDowntime, st)
+	}
+
+	h = newHealth(time.Hour)
+	now = h.started
+	h.now = func() time.Time { return now }
+	h.sawMessage()
+	now = now.Add(59 * time.Minute)
+	h.sawMessage()
+	now = now.Add(59 * time.Minute)
+	if st := h.status(); st.SinceLastMessage != "59m0s" {
+		t.Errorf("expected 59m since the last message, got %+v", st)
+	}
+	h.setAuth(nil)
+	h.setConn(&chatConn{sess: &dggchat.Session{}})
+	if st := h.status(); !st.Ready {
+		t.Errorf("expected ready before an hour of silence, got %+v", st)
+	}
+	now = now.Add(2 * time.Minute)
+	if st := h.status(); !st.Live || st.Ready || len(st.Problems) != 1 {
+		t.Errorf("expected live but not ready after an hour of silence, got %+v", st)
+	}
+
+	h.setAuth(errors.New("status code 401"))
+	if st := h.status(); st.AuthError != "status code 401" || st.AuthChecked == "" {
+		t.Errorf("expected the auth error, got %+v", st)
+	}
+}
+
+func getHealth(t *testing.T, h http.Handler) (int, healthStatus) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	var st healthStatus
+	if err := json.Unmarshal(rec.Body.Bytes(), &st); err != nil {
+		t.Fatalf("unexpected body %q: %v", rec.Body.String(), err)
+	}
+	return rec.Code, st
+}
+
+func TestHealthEndpoints(t *testing.T) {
+	c := newMockChat(t, testMod, testUser)
+	b, conn := connectTestConn(t, c, 0, nil)
+	b.health.setConn(conn)
+	healthz := b.health.handler(func(st healthStatus) bool { return st.Live })
+	readyz := b.health.handler(func(st healthStatus) bool { return st.Ready })
+
+	if code, st := getHealth(t, readyz); code != http.StatusServiceUnavailable || !st.Connected {
+		t.Errorf("expected connected but not ready before the auth check, got %d %+v", code, st)
+	}
+	b.health.setAuth(nil)
+	if code, st := getHealth(t, readyz); code != http.StatusOK || !st.Ready {
+		t.Errorf("expected ready, got %d %+v", code, st)
+	}
+
+	c.drop(100)
+	deadline := time.Now().Add(5 * time.Second)
+	for conn.stats().connected {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the connection to drop")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if code, st := getHealth(t, readyz); code != http.StatusServiceUnavailable || st.Connected {
+		t.Errorf("expected not ready while reconnecting, got %d %+v", code, st)
+	}
+	if code, st := getHealth(t, healthz); code != http.StatusOK || st.DownFor == "" {
+		t.Errorf("expected live while reconnecting, got %d %+v", code, st)
+	}
+}
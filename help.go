@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/MemeLabs/dggchat"
+)
+
+// usage returns the command name, aliases and argument spec, e.g. "!stream|!strim".
+func (c *command) usage() string {
+	names := strings.Join(append([]string{c.name}, c.aliases...), "|")
+	if c.args == "" {
+		return names
+	}
+	return names + " " + c.args
+}
+
+// lookupCommand finds a registered command by name or alias, the leading "!"
+// is optional.
+func (b *bot) lookupCommand(name string) (*command, bool) {
+	if !strings.HasPrefix(name, "!") {
+		name = "!" + name
+	}
+	c, ok := b.commandLookup[name]
+	return c, ok
+}
+
+// commandNames lists the names of all commands requiring exactly role r.
+func (b *bot) commandNames(r role) []string {
+	names := []string{}
+	for _, c := range b.commands {
+		if c.role == r {
+			names = append(names, c.name)
+		}
+	}
+	return names
+}
+
+// !help [command] - list commands or show usage of a single command.
+// Public commands are answered in chat, privileged ones via PM.
+func (b *bot) help(m dggchat.Message, s chatSession) {
+	parts := strings.Fields(m.Message)
+
+	if len(parts) >= 2 {
+		c, ok := b.lookupCommand(parts[1])
+		if !ok || b.roleOf(m.Sender) < c.role {
+			return
+		}
+		out := fmt.Sprintf("%s - %s", c.usage(), c.help)
+		if c.role == roleEveryone {
+			b.sendMessage(out, s)
+		} else {
+			s.SendPrivateMessage(m.Sender.Nick, out)
+		}
+		return
+	}
+
+	b.sendMessage(fmt.Sprintf("commands: %s - try !help command",
+		strings.Join(b.commandNames(roleEveryone), " ")), s)
+	for r := roleTrusted; r <= b.roleOf(m.Sender); r++ {
+		if names := b.commandNames(r); len(names) > 0 {
+			s.SendPrivateMessage(m.Sender.Nick, fmt.Sprintf("%s commands: %s", r, strings.Join(names, " ")))
+		}
+	}
+}
+
+// writeHelpTable writes the markdown command tables used in the README.
+func (b *bot) writeHelpTable(w io.Writer) error {
+	escape := strings.NewReplacer("|", "\\|").Replace
+	first := true
+	for r := roleAdmin; r >= roleEveryone; r-- {
+		if len(b.commandNames(r)) == 0 {
+			continue
+		}
+		if !first {
+			fmt.Fprintln(w)
+		}
+		first = false
+
+		title := r.String() + " commands"
+		if r == roleEveryone {
+			title = "public commands"
+		}
+		fmt.Fprintf(w, "### %s\n\n", title)
+		fmt.Fprintln(w, "| Command | Arguments | Example | Description |")
+		fmt.Fprintln(w, "| --- | --- | --- | --- |")
+		for _, c := range b.commands {
+			if c.role != r {
+				continue
+			}
+			names := strings.Join(append([]string{c.name}, c.aliases...), "|")
+			_, err := fmt.Fprintf(w, "| %s | %s | %s | %s |\n",
+				escape(names), escape(c.args), escape(c.example), escape(c.help))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+// The README command tables are generated, make sure they don't drift.
+func TestReadmeCommandTable(t *testing.T) {
+	b := newBot(0)
+	b.registerCommands(b.defaultCommands()...)
+
+	var buf bytes.Buffer
+	if err := b.writeHelpTable(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	readme, err := ioutil.ReadFile("README.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(readme, buf.Bytes()) {
+		t.Error("README.md command tables are outdated, regenerate with -dump-help")
+	}
+}
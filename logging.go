@@ -0,0 +1,430 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// logLevel is the minimum severity a sink writes.
+type logLevel int
+
+const (
+	levelDebug logLevel = iota
+	levelInfo
+	levelWarn
+	levelError
+	// levelOff disables a sink.
+	levelOff
+)
+
+var levelNames = []string{"debug", "info", "warn", "error", "off"}
+
+func (l logLevel) String() string {
+	if l < 0 || int(l) >= len(levelNames) {
+		return fmt.Sprintf("level(%d)", int(l))
+	}
+	return levelNames[l]
+}
+
+func (l logLevel) MarshalText() ([]byte, error) {
+	return []byte(l.String()), nil
+}
+
+func (l *logLevel) UnmarshalText(b []byte) error {
+	for i, n := range levelNames {
+		if strings.EqualFold(n, string(b)) {
+			*l = logLevel(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown log level %q, use one of %s", b, strings.Join(levelNames, ", "))
+}
+
+// logger writes to a single sink. The chat transcript, bot events and debug
+// output each have their own.
+type logger struct {
+	// tag prefixes text lines, so replay can tell them from chat lines.
+	tag string
+	// caller adds the file and line of the call.
+	caller bool
+
+	mu    sync.Mutex
+	level logLevel
+	json  bool
+	out   io.Writer
+}
+
+var (
+	chatLog  = &logger{level: levelInfo, out: os.Stdout}
+	eventLog = &logger{tag: "[##]", level: levelInfo, out: os.Stdout}
+	debugLog = &logger{tag: "[d]", caller: true, level: levelDebug, out: os.Stdout}
+)
+
+func (l *logger) configure(level logLevel, jsonFormat bool, out io.Writer) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.level, l.json, l.out = level, jsonFormat, out
+}
+
+func (l *logger) enabled(level logLevel) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return level >= l.level && l.level != levelOff
+}
+
+func (l *logger) write(line []byte) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	// nowhere to report a failing log, the next write may work again.
+	l.out.Write(line)
+}
+
+type logEntry struct {
+	Time   string `json:"time"`
+	Level  string `json:"level"`
+	Caller string `json:"caller,omitempty"`
+	Msg    string `json:"msg"`
+}
+
+// logf writes a line at level, depth is the number of frames to skip to the
+// caller.
+func (l *logger) logf(depth int, level logLevel, format string, args ...interface{}) {
+	if !l.enabled(level) {
+		return
+	}
+	now := time.Now()
+	msg := strings.TrimSuffix(fmt.Sprintf(format, args...), "\n")
+	caller := ""
+	if l.caller {
+		if _, file, line, ok := runtime.Caller(depth + 1); ok {
+			caller = fmt.Sprintf("%s:%d", filepath.Base(file), line)
+		}
+	}
+
+	l.mu.Lock()
+	jsonFormat := l.json
+	l.mu.Unlock()
+	if jsonFormat {
+		data, err := json.Marshal(logEntry{now.Format(time.RFC3339Nano), level.String(), caller, msg})
+		if err != nil {
+			return
+		}
+		l.write(append(data, '\n'))
+		return
+	}
+
+	parts := []string{now.Format(logTimeLayout)}
+	if l.tag != "" {
+		parts = append(parts, l.tag)
+	}
+	parts = append(parts, strings.ToUpper(level.String()))
+	if caller != "" {
+		parts = append(parts, caller+":")
+	}
+	l.write([]byte(strings.Join(append(parts, msg), " ") + "\n"))
+}
+
+func (l *logger) debugf(format string, args ...interface{}) {
+	l.logf(1, levelDebug, format, args...)
+}
+
+func (l *logger) infof(format string, args ...interface{}) {
+	l.logf(1, levelInfo, format, args...)
+}
+
+func (l *logger) warnf(format string, args ...interface{}) {
+	l.logf(1, levelWarn, format, args...)
+}
+
+func (l *logger) errorf(format string, args ...interface{}) {
+	l.logf(1, levelError, format, args...)
+}
+
+// message writes a chat line to the transcript, as JSON in replay format or
+// as text replay can read.
+func (l *logger) message(nick, message string, features []string, t time.Time, private bool) {
+	if !l.enabled(levelInfo) {
+		return
+	}
+	l.mu.Lock()
+	jsonFormat := l.json
+	l.mu.Unlock()
+
+	if jsonFormat {
+		data, err := json.Marshal(replayLine{
+			Nick:      nick,
+			Features:  features,
+			Timestamp: t.UnixNano() / int64(time.Millisecond),
+			Data:      message,
+			Private:   private,
+		})
+		if err != nil {
+			return
+		}
+		l.write(append(data, '\n'))
+		return
+	}
+
+	prefix := ""
+	if private {
+		prefix = "[#] PM: "
+	}
+	l.write([]byte(fmt.Sprintf("%s %s%s: %s\n", t.Format(logTimeLayout), prefix, nick, message)))
+}
+
+// stdLogWriter sends the standard logger, used for fatal errors, to the
+// event log.
+type stdLogWriter struct{}
+
+func (stdLogWriter) Write(p []byte) (int, error) {
+	eventLog.logf(1, levelError, "%s", p)
+	return len(p), nil
+}
+
+// sinkConfig configures a log sink.
+type sinkConfig struct {
+	// Path is the file to write to, empty writes to stdout.
+	Path string `json:"path"`
+	// Stdout also writes to stdout if Path is set.
+	Stdout bool     `json:"stdout"`
+	Format string   `json:"format"`
+	Level  logLevel `json:"level"`
+	// MaxSizeMB rotates the file once it would grow past this size.
+	MaxSizeMB int `json:"max_size_mb"`
+	// RotateEvery rotates the file at multiples of this interval since the
+	// unix epoch, e.g. at midnight UTC for 24h.
+	RotateEvery duration `json:"rotate_every"`
+	// Keep is the number of rotated files kept, named path.1 to path.N.
+	Keep int `json:"keep"`
+}
+
+type logConfig struct {
+	Chat   *sinkConfig `json:"chat"`
+	Events *sinkConfig `json:"events"`
+	Debug  *sinkConfig `json:"debug"`
+}
+
+// defaultLogConfig writes chat and events to transcript and everything to
+// stdout, without rotation.
+func defaultLogConfig(transcript string) *logConfig {
+	return &logConfig{
+		Chat:   &sinkConfig{Path: transcript, Stdout: true, Format: "text", Level: levelInfo},
+		Events: &sinkConfig{Path: transcript, Stdout: true, Format: "text", Level: levelInfo},
+		Debug:  &sinkConfig{Format: "text", Level: levelDebug},
+	}
+}
+
+// loadLogConfig reads path, settings missing from it keep the defaults and
+// null disables a sink.
+func loadLogConfig(path, transcript string) (*logConfig, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := defaultLogConfig(transcript)
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	for _, sink := range []**sinkConfig{&cfg.Chat, &cfg.Events, &cfg.Debug} {
+		if *sink == nil {
+			*sink = &sinkConfig{Level: levelOff}
+		}
+		s := *sink
+		if s.Format == "" {
+			s.Format = "text"
+		}
+		if s.Format != "text" && s.Format != "json" {
+			return nil, fmt.Errorf("%s: unknown log format %q, use text or json", path, s.Format)
+		}
+	}
+	return cfg, nil
+}
+
+// logFiles are the files opened for the sinks, sinks writing to the same
+// path share a file and the rotation settings of the first.
+type logFiles map[string]*logFile
+
+// setupLogging points the loggers at their sinks.
+func setupLogging(cfg *logConfig) (logFiles, error) {
+	sinks := []struct {
+		l   *logger
+		cfg *sinkConfig
+	}{{chatLog, cfg.Chat}, {eventLog, cfg.Events}, {debugLog, cfg.Debug}}
+
+	// open all files first, so a failure leaves the loggers untouched.
+	files := logFiles{}
+	for _, sink := range sinks {
+		if _, ok := files[sink.cfg.Path]; ok || sink.cfg.Path == "" {
+			continue
+		}
+		f, err := openLogFile(sink.cfg.Path, int64(sink.cfg.MaxSizeMB)<<20, sink.cfg.RotateEvery.Duration, sink.cfg.Keep)
+		if err != nil {
+			files.close()
+			return nil, err
+		}
+		files[sink.cfg.Path] = f
+	}
+
+	for _, sink := range sinks {
+		var out io.Writer = os.Stdout
+		if f, ok := files[sink.cfg.Path]; ok {
+			out = f
+			if sink.cfg.Stdout {
+				out = io.MultiWriter(os.Stdout, f)
+			}
+		}
+		sink.l.configure(sink.cfg.Level, sink.cfg.Format == "json", out)
+	}
+
+	log.SetFlags(0)
+	log.SetOutput(stdLogWriter{})
+	return files, nil
+}
+
+// reopen reopens all files, after an external tool rotated them.
+func (files logFiles) reopen() error {
+	for _, f := range files {
+		if err := f.reopen(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (files logFiles) close() {
+	for _, f := range files {
+		f.Close()
+	}
+}
+
+// logFile is a log file rotating itself by size and time.
+type logFile struct {
+	path    string
+	maxSize int64
+	every   time.Duration
+	keep    int
+	// now is a var so tests can rotate by time.
+	now func() time.Time
+
+	mu     sync.Mutex
+	f      *os.File
+	size   int64
+	opened time.Time
+}
+
+func openLogFile(path string, maxSize int64, every time.Duration, keep int) (*logFile, error) {
+	if dir := filepath.Dir(path); !fileExists(dir) {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, err
+		}
+	}
+	l := &logFile{path: path, maxSize: maxSize, every: every, keep: keep, now: time.Now}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *logFile) open() error {
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.f, l.size, l.opened = f, info.Size(), l.now()
+	// an existing file belongs to the interval it was last written in.
+	if l.size > 0 {
+		l.opened = info.ModTime()
+	}
+	return nil
+}
+
+func (l *logFile) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return 0, os.ErrClosed
+	}
+	if l.due(len(p)) {
+		if err := l.rotate(); err != nil {
+			fmt.Fprintf(os.Stderr, "rotating %s: %s\n", l.path, err.Error())
+			if l.f == nil {
+				return 0, err
+			}
+		}
+	}
+	n, err := l.f.Write(p)
+	l.size += int64(n)
+	return n, err
+}
+
+// due tells whether writing n more bytes needs a rotation first.
+func (l *logFile) due(n int) bool {
+	if l.size == 0 {
+		return false
+	}
+	if l.maxSize > 0 && l.size+int64(n) > l.maxSize {
+		return true
+	}
+	return l.every > 0 && l.now().Truncate(l.every).After(l.opened.Truncate(l.every))
+}
+
+// rotate shifts path.N-1 to path.N and so on, moves the current file to
+// path.1 and starts a new one. If that fails the current file is reopened.
+func (l *logFile) rotate() error {
+	l.f.Close()
+	l.f = nil
+	err := l.shift()
+	if openErr := l.open(); openErr != nil {
+		return openErr
+	}
+	return err
+}
+
+func (l *logFile) shift() error {
+	if l.keep < 1 {
+		return os.Remove(l.path)
+	}
+	for i := l.keep - 1; i >= 1; i-- {
+		from := fmt.Sprintf("%s.%d", l.path, i)
+		if fileExists(from) {
+			if err := os.Rename(from, fmt.Sprintf("%s.%d", l.path, i+1)); err != nil {
+				return err
+			}
+		}
+	}
+	return os.Rename(l.path, l.path+".1")
+}
+
+func (l *logFile) reopen() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f != nil {
+		l.f.Close()
+	}
+	return l.open()
+}
+
+func (l *logFile) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return nil
+	}
+	err := l.f.Close()
+	l.f = nil
+	return err
+}
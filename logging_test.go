@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoggerFormats(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := &logger{tag: "[##]"}
+	l.configure(levelInfo, false, buf)
+	l.debugf("hidden")
+	l.infof("nuke: '%s'\n", "badword")
+	l.configure(levelWarn, true, buf)
+	l.infof("hidden")
+	l.errorf("send error: %s", "closed")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got %q", lines)
+	}
+	if !strings.HasSuffix(lines[0], " [##] INFO nuke: 'badword'") {
+		t.Errorf("unexpected text line %q", lines[0])
+	}
+	var e logEntry
+	if err := json.Unmarshal([]byte(lines[1]), &e); err != nil {
+		t.Fatal(err)
+	}
+	if e.Level != "error" || e.Msg != "send error: closed" {
+		t.Errorf("unexpected JSON line %+v", e)
+	}
+}
+
+func TestChatTranscriptReplays(t *testing.T) {
+	ts := time.Date(2026, 10, 18, 12, 0, 1, 0, time.Local)
+	for _, jsonFormat := range []bool{false, true} {
+		buf := &bytes.Buffer{}
+		l := &logger{}
+		l.configure(levelInfo, jsonFormat, buf)
+		l.message("mod", "!nukes", []string{"moderator"}, ts, true)
+
+		rl, ok, err := parseReplayLine(buf.String())
+		if err != nil || !ok {
+			t.Fatalf("json %v: can't replay %q: %v", jsonFormat, buf.String(), err)
+		}
+		if rl.Nick != "mod" || rl.Data != "!nukes" || !rl.Private ||
+			rl.Timestamp != ts.UnixNano()/int64(time.Millisecond) {
+			t.Errorf("json %v: unexpected line %+v", jsonFormat, rl)
+		}
+	}
+}
+
+func TestLogFileRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "modbot-logs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "sub", "events.log")
+
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	f, err := openLogFile(path, 10, 24*time.Hour, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	f.now = func() time.Time { return now }
+	f.opened = now
+
+	// by size, keeping only the 2 latest files
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if _, err := f.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for suffix, want := range map[string]string{"": "fourth\n", ".1": "third\n", ".2": "second\n"} {
+		if data, _ := ioutil.ReadFile(path + suffix); string(data) != want {
+			t.Errorf("expected %q in %s, got %q", want, path+suffix, data)
+		}
+	}
+	if fileExists(path + ".3") {
+		t.Error("expected no more than 2 rotated files")
+	}
+
+	// by time, at midnight
+	f.maxSize = 0
+	f.Write([]byte("a\n"))
+	now = now.Add(11 * time.Hour)
+	f.Write([]byte("b\n"))
+	now = now.Add(time.Hour)
+	f.Write([]byte("c\n"))
+	if data, _ := ioutil.ReadFile(path + ".1"); string(data) != "fourth\na\nb\n" {
+		t.Errorf("expected a rotation at midnight, got %q", data)
+	}
+}
+
+func TestLoadLogConfig(t *testing.T) {
+	f, err := ioutil.TempFile("", "modbot-logging")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`{"events": {"format": "json", "level": "warn"}, "debug": null}`)
+	f.Close()
+
+	cfg, err := loadLogConfig(f.Name(), "chat.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Chat.Path != "chat.log" || cfg.Chat.Format != "text" {
+		t.Errorf("expected default chat sink, got %+v", cfg.Chat)
+	}
+	if cfg.Events.Path != "chat.log" || cfg.Events.Format != "json" || cfg.Events.Level != levelWarn {
+		t.Errorf("unexpected events sink %+v", cfg.Events)
+	}
+	if cfg.Debug.Level != levelOff {
+		t.Errorf("expected debug to be off, got %+v", cfg.Debug)
+	}
+
+	ioutil.WriteFile(f.Name(), []byte(`{"chat": {"format": "xml"}}`), 0o644)
+	if _, err := loadLogConfig(f.Name(), "chat.log"); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
@@ -3,160 +3,183 @@ package main
 import (
 	"encoding/json"
 	"flag"
-	"io"
+	"fmt"
 	"io/ioutil"
 	"log"
+	"net/http"
 	"net/url"
 	"os"
 	"os/signal"
-	"path"
+	"strings"
 	"syscall"
-	"time"
-
-	"github.com/MemeLabs/dggchat"
-)
-
-var (
-	debuglogger  = log.New(os.Stdout, "[d] ", log.Ldate|log.Ltime|log.Lshortfile)
-	authCookie   string
-	chatPath     string
-	chatURL      string
-	backendURL   string
-	logFileName  string
-	commandJSON  string
-	atAdminToken string
-	logOnly      bool
-	logFile      *os.File
-)
-
-const (
-	websiteURL   = "strims.gg"
-	pollTime     = time.Second * 2
-	ominousEmote = "BOGGED"
 )
 
 func main() {
-	flag.StringVar(&authCookie, "cookie", "", "Cookie used for chat authentication and API access")
-	flag.StringVar(&chatPath, "path", "", "path to chat-gui")
-	flag.StringVar(&chatURL, "chat", "wss://chat.strims.gg/ws", "ws(s)-url for chat")
-	flag.StringVar(&backendURL, "api", "https://strims.gg/api", "basic backend api path")
-	flag.StringVar(&logFileName, "log", "/tmp/chatlog/chatlog.log", "file to write messages to")
-	flag.StringVar(&commandJSON, "commands", "commands.json", "static commands file")
-	flag.StringVar(&atAdminToken, "attoken", "", "angelthump admin token (optional)")
-	flag.BoolVar(&logOnly, "logonly", false, "only 'reply' to logfile, not chat (for debugging)")
+	// flags are parsed again by readConfig, on top of the config file.
+	opts := &options{}
+	registerFlags(flag.CommandLine, defaultConfig(), opts)
 	flag.Parse()
 
-	loadStaticCommands()
+	if opts.healthcheck != "" {
+		if err := checkHealth(opts.healthcheck); err != nil {
+			log.Fatalln(err)
+		}
+		return
+	}
 
-	// TODO dggchat lib isn't flexible with the cookie name, workaround...
-	dgg, err := dggchat.New(";jwt=" + authCookie)
+	readCfg := func() (*config, error) {
+		return readConfig(opts.config, os.Args[1:])
+	}
+	cfg, err := readCfg()
 	if err != nil {
 		log.Fatalln(err)
 	}
 
 	// init bot
-	b := newBot(authCookie, 250)
-	b.addParser(
-		b.staticMessage,
-		b.nuke,
-		b.aegis,
-		b.noShortMsgSpam,
-		b.rename,
-		b.say,
-		b.addCommand,
-		b.mute,
-		b.unmute,
-		b.printTopStreams,
-		b.modifyStream,
-		b.checkAT,
-		b.dropAT,
-		b.provideAltAngelthumpLink,
-		b.ban,
-		b.sudoku,
-		b.frenchToastAlert,
-	)
-	dgg.AddMessageHandler(b.onMessage)
-	dgg.AddErrorHandler(b.onError)
-	dgg.AddMuteHandler(b.onMute)
-	dgg.AddUnmuteHandler(b.onUnmute)
-	dgg.AddBanHandler(b.onBan)
-	dgg.AddUnbanHandler(b.onUnban)
-	dgg.AddSocketErrorHandler(b.onSocketError)
-	dgg.AddPMHandler(b.onPMHandler)
-
-	u, err := url.Parse(chatURL)
+	b := newBot(250)
+	b.readConfig = readCfg
+	b.registerDefaults()
+
+	if opts.dumpHelp {
+		if err := b.writeHelpTable(os.Stdout); err != nil {
+			log.Fatalln(err)
+		}
+		return
+	}
+
+	// replay prints to stdout only.
+	if opts.replay == "" {
+		b.logs = logFiles{}
+	}
+	b.mu.Lock()
+	err = b.applyConfig(cfg)
+	b.mu.Unlock()
 	if err != nil {
 		log.Fatalln(err)
 	}
-	dgg.SetURL(*u)
+	if opts.replay != "" {
+		runReplay(b, opts)
+		return
+	}
+	eventLog.infof("restart")
+	go b.watchRules()
 
-	err = dgg.Open()
+	modlog, err := openAuditLog(cfg.Audit)
 	if err != nil {
 		log.Fatalln(err)
 	}
-	debuglogger.Println("[##] connected...")
-	defer dgg.Close()
+	b.modlog = modlog
 
-	info, err := b.getProfileInfo()
-	if err != nil {
-		debuglogger.Printf("userinfo: %s\n", err.Error())
-	} else {
-		debuglogger.Printf("userinfo: '%+v'\n", info)
+	if cfg.State != "" {
+		store, err := newFileStore(cfg.State)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		b.store = store
+		if err := b.loadState(); err != nil {
+			log.Fatalln(err)
+		}
+		go b.persistState()
 	}
 
-	// log to file and stdout
-	logFile = reOpenLog()
-	log.Println("[##] Restart")
+	b.out = newOutbox(defaultSendInterval)
+	go b.out.run()
+
+	if cfg.MetricsAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics)
+		mux.Handle("/healthz", b.health.handler(func(st healthStatus) bool { return st.Live }))
+		mux.Handle("/readyz", b.health.handler(func(st healthStatus) bool { return st.Ready }))
+		if err := serveHTTP(cfg.MetricsAddr, mux); err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	u, err := url.Parse(cfg.ChatURL)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	dgg, err := b.connect(cfg.Cookie, *u, cfg.MaxReconnects)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	debugLog.debugf("connected...")
+	defer dgg.Close()
+	b.mu.Lock()
+	b.conn = dgg
+	b.mu.Unlock()
+	b.out.follow(dgg.session)
+	b.registerChatMetrics(dgg)
+	b.health.setConn(dgg)
+
+	b.preflight()
+	if cfg.AuthCheck.Duration > 0 {
+		go b.watchAuth(cfg.AuthCheck.Duration)
+	}
 
 	signals := make(chan os.Signal, 1)
 	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
 
-	if logOnly {
-		debuglogger.Println("[##] started in logonly mode.")
+	if cfg.LogOnly {
+		debugLog.debugf("started in logonly mode.")
 	}
-	debuglogger.Println("[##] waiting for signals...")
+	debugLog.debugf("waiting for signals...")
 	for {
 		sig := <-signals
 		switch sig {
 
-		// handle logrotate request from daemon
+		// reload the config, which also reopens the logs for logrotate
 		case syscall.SIGHUP:
-			log.Println("[##] signal: handling SIGHUP")
-			err = logFile.Close()
-			if err != nil {
-				panic(err)
-			}
-			logFile = reOpenLog()
+			eventLog.infof("signal: handling SIGHUP")
+			b.mu.Lock()
+			b.reload()
+			b.mu.Unlock()
 
 		// exit on interrupt
 		case syscall.SIGTERM:
 			fallthrough
 		case syscall.SIGINT:
-			log.Println("[##] signal: handling SIGINT/SIGTERM")
-			err = logFile.Close()
-			if err != nil {
-				log.Printf("[##] error in cleanup: %s\n", err.Error())
+			eventLog.infof("signal: handling SIGINT/SIGTERM")
+			if err := b.saveState(); err != nil {
+				eventLog.errorf("error saving state: %s\n", err.Error())
+			}
+			if err := b.modlog.close(); err != nil {
+				eventLog.errorf("error closing audit log: %s\n", err.Error())
 			}
+			b.mu.Lock()
+			b.logs.close()
+			b.mu.Unlock()
 			os.Exit(1)
 		}
 	}
 }
 
-func reOpenLog() *os.File {
-	dir, _ := path.Split(logFileName)
-	if !fileExists(dir) {
-		if err := os.MkdirAll(dir, 0o755); err != nil {
-			panic(err)
+// runReplay replays opts.replay without connecting to chat or the backends.
+func runReplay(b *bot, opts *options) {
+	f, err := os.Open(opts.replay)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	defer f.Close()
+
+	// keep admin commands away from the real backends and config files.
+	b.strims = newStrimsClient("", "")
+	b.at = newAngelthumpClient("", "")
+	cfg := *b.cfg
+	cfg.Commands = os.DevNull
+	cfg.Roles = ""
+	b.cfg = &cfg
+	b.readConfig = nil
+
+	mods := map[string]bool{}
+	for _, nick := range strings.Split(opts.replayMods, ",") {
+		if nick = strings.TrimSpace(nick); nick != "" {
+			mods[strings.ToLower(nick)] = true
 		}
 	}
-
-	f, err := os.OpenFile(logFileName, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o755)
-	if err != nil {
-		panic(err)
+	if err := b.replay(f, os.Stdout, mods); err != nil {
+		log.Fatalln(err)
 	}
-	mw := io.MultiWriter(os.Stdout, f)
-	log.SetOutput(mw)
-	return f
 }
 
 func fileExists(name string) bool {
@@ -168,37 +191,36 @@ func fileExists(name string) bool {
 	return true
 }
 
-func loadStaticCommands() {
-	if !fileExists(commandJSON) {
-		log.Printf("creating empty commands file %s\n", commandJSON)
-		os.Create(commandJSON)
-		err := ioutil.WriteFile(commandJSON, []byte("{}"), 0o755)
-		if err != nil {
-			panic(err)
+// loadStaticCommands reads the static commands at path, creating an empty
+// file if there is none.
+func loadStaticCommands(path string) (map[string]string, error) {
+	if !fileExists(path) {
+		eventLog.infof("creating empty commands file %s\n", path)
+		if err := ioutil.WriteFile(path, []byte("{}"), 0o755); err != nil {
+			return nil, err
 		}
 	}
 
-	b, err := ioutil.ReadFile(commandJSON)
+	b, err := ioutil.ReadFile(path)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 	var cmnd map[string]string
-	err = json.Unmarshal(b, &cmnd)
-	if err != nil {
-		panic(err)
+	if err := json.Unmarshal(b, &cmnd); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
 	}
-	commands = cmnd
+	return cmnd, nil
 }
 
-func saveStaticCommands() bool {
+func saveStaticCommands(path string) bool {
 	s, err := json.MarshalIndent(commands, "", "\t")
 	if err != nil {
-		log.Printf("failed marshaling commands, error: %v\n", err)
+		eventLog.errorf("failed marshaling commands, error: %v\n", err)
 		return false
 	}
-	err = ioutil.WriteFile(commandJSON, s, 0o755)
+	err = ioutil.WriteFile(path, s, 0o755)
 	if err != nil {
-		log.Printf("failed saving commands, error: %v\n", err)
+		eventLog.errorf("failed saving commands, error: %v\n", err)
 		return false
 	}
 	return true
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metric is written in the prometheus text format.
+type metric interface {
+	write(w io.Writer)
+}
+
+// metricsRegistry serves all registered metrics.
+type metricsRegistry struct {
+	mu      sync.Mutex
+	metrics []metric
+}
+
+func (r *metricsRegistry) register(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.metrics = append(r.metrics, m)
+}
+
+func (r *metricsRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	r.mu.Lock()
+	metrics := append([]metric{}, r.metrics...)
+	r.mu.Unlock()
+	for _, m := range metrics {
+		m.write(w)
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// labelString formats label pairs, extra is appended as is, e.g. le="1".
+func labelString(names, values []string, extra string) string {
+	pairs := []string{}
+	for i, name := range names {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, name, labelEscaper.Replace(values[i])))
+	}
+	if extra != "" {
+		pairs = append(pairs, extra)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// series holds the label values of a vector in a stable order.
+type series struct {
+	mu     sync.Mutex
+	labels []string
+	keys   []string
+	values map[string][]string
+}
+
+// key returns the key of the label values, adding them if they are new.
+func (s *series) key(values []string) string {
+	if len(values) != len(s.labels) {
+		panic(fmt.Sprintf("expected %d label values, got %d", len(s.labels), len(values)))
+	}
+	k := strings.Join(values, "\xff")
+	if _, ok := s.values[k]; !ok {
+		s.values[k] = append([]string{}, values...)
+		s.keys = append(s.keys, k)
+		sort.Strings(s.keys)
+	}
+	return k
+}
+
+// counterVec is a counter partitioned by labels.
+type counterVec struct {
+	name, help string
+	series
+	counts map[string]float64
+}
+
+func newCounterVec(r *metricsRegistry, name, help string, labels ...string) *counterVec {
+	c := &counterVec{
+		name: name, help: help,
+		series: series{labels: labels, values: map[string][]string{}},
+		counts: map[string]float64{},
+	}
+	r.register(c)
+	return c
+}
+
+func (c *counterVec) add(v float64, values ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.counts[c.key(values)] += v
+}
+
+func (c *counterVec) inc(values ...string) {
+	c.add(1, values...)
+}
+
+// value returns the current count, for tests.
+func (c *counterVec) value(values ...string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.counts[strings.Join(values, "\xff")]
+}
+
+func (c *counterVec) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	if len(c.labels) == 0 && len(c.keys) == 0 {
+		fmt.Fprintf(w, "%s 0\n", c.name)
+	}
+	for _, k := range c.keys {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, labelString(c.labels, c.values[k], ""), formatFloat(c.counts[k]))
+	}
+}
+
+type histogramCounts struct {
+	buckets []uint64
+	sum     float64
+	count   uint64
+}
+
+// histogramVec is a histogram partitioned by labels.
+type histogramVec struct {
+	name, help string
+	bounds     []float64
+	series
+	counts map[string]*histogramCounts
+}
+
+func newHistogramVec(r *metricsRegistry, name, help string, bounds []float64, labels ...string) *histogramVec {
+	h := &histogramVec{
+		name: name, help: help, bounds: bounds,
+		series: series{labels: labels, values: map[string][]string{}},
+		counts: map[string]*histogramCounts{},
+	}
+	r.register(h)
+	return h
+}
+
+func (h *histogramVec) observe(v float64, values ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	k := h.key(values)
+	c, ok := h.counts[k]
+	if !ok {
+		c = &histogramCounts{buckets: make([]uint64, len(h.bounds))}
+		h.counts[k] = c
+	}
+	for i, bound := range h.bounds {
+		if v <= bound {
+			c.buckets[i]++
+		}
+	}
+	c.sum += v
+	c.count++
+}
+
+func (h *histogramVec) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	for _, k := range h.keys {
+		c, values := h.counts[k], h.values[k]
+		for i, bound := range h.bounds {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name,
+				labelString(h.labels, values, fmt.Sprintf(`le="%s"`, formatFloat(bound))), c.buckets[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labelString(h.labels, values, `le="+Inf"`), c.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, labelString(h.labels, values, ""), formatFloat(c.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, labelString(h.labels, values, ""), c.count)
+	}
+}
+
+// funcMetric reports the value of f when scraped.
+type funcMetric struct {
+	name, help, kind string
+	f                func() float64
+}
+
+func newGaugeFunc(r *metricsRegistry, name, help string, f func() float64) {
+	r.register(funcMetric{name, help, "gauge", f})
+}
+
+// newCounterFunc registers a counter kept elsewhere, f has to be monotonic.
+func newCounterFunc(r *metricsRegistry, name, help string, f func() float64) {
+	r.register(funcMetric{name, help, "counter", f})
+}
+
+func (m funcMetric) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %s\n", m.name, m.help, m.name, m.kind, m.name, formatFloat(m.f()))
+}
+
+var (
+	metrics = &metricsRegistry{}
+
+	messagesSeen = newCounterVec(metrics, "modbot_messages_total",
+		"Chat messages seen.")
+	commandsRun = newCounterVec(metrics, "modbot_commands_total",
+		"Commands executed, by name.", "command")
+	ruleActions = newCounterVec(metrics, "modbot_rule_actions_total",
+		"Warnings, mutes and bans by spam rules.", "rule", "action")
+	nukeVictims = newHistogramVec(metrics, "modbot_nuke_victims",
+		"Users muted when a nuke fires.", []float64{0, 1, 2, 5, 10, 25, 50, 100})
+	nukeLateVictims = newCounterVec(metrics, "modbot_nuke_late_victims_total",
+		"Users muted by an armed nuke after it fired.")
+	apiDuration = newHistogramVec(metrics, "modbot_api_request_duration_seconds",
+		"Latency of backend api requests.", []float64{.05, .1, .25, .5, 1, 2, 4}, "api")
+	apiRequests = newCounterVec(metrics, "modbot_api_requests_total",
+		"Backend api requests, by status code or error.", "api", "code")
+	reconnectAttempts = newCounterVec(metrics, "modbot_reconnect_attempts_total",
+		"Attempts to reconnect to chat.", "result")
+)
+
+// metricsTransport records latency and results of api requests.
+type metricsTransport struct {
+	api  string
+	next http.RoundTripper
+}
+
+func (t metricsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	apiDuration.observe(time.Since(start).Seconds(), t.api)
+	if err != nil {
+		apiRequests.inc(t.api, "error")
+		return nil, err
+	}
+	apiRequests.inc(t.api, strconv.Itoa(resp.StatusCode))
+	return resp, nil
+}
+
+// serveHTTP listens on addr and serves h in the background.
+func serveHTTP(addr string, h http.Handler) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	eventLog.infof("serving metrics on %s\n", l.Addr())
+	go func() {
+		eventLog.errorf("metrics listener: %s\n", http.Serve(l, h).Error())
+	}()
+	return nil
+}
+
+// registerChatMetrics exports the outbox and connection state.
+func (b *bot) registerChatMetrics(c *chatConn) {
+	newGaugeFunc(metrics, "modbot_outbox_queue_depth", "Messages waiting to be sent.", func() float64 {
+		return float64(b.out.depth())
+	})
+	newGaugeFunc(metrics, "modbot_chat_connected", "1 while connected to chat.", func() float64 {
+		if c.stats().connected {
+			return 1
+		}
+		return 0
+	})
+	newCounterFunc(metrics, "modbot_chat_outages_total", "Lost chat connections.", func() float64 {
+		return float64(c.stats().outages)
+	})
+	newCounterFunc(metrics, "modbot_chat_downtime_seconds_total", "Time spent reconnecting to chat.", func() float64 {
+		return c.stats().downtime.Seconds()
+	})
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetricsFormat(t *testing.T) {
+	r := &metricsRegistry{}
+	c := newCounterVec(r, "test_total", "Test counter.", "name")
+	c.inc(`say "hi"`)
+	c.add(2, "a")
+	newCounterVec(r, "test_plain_total", "Unused counter.")
+	h := newHistogramVec(r, "test_seconds", "Test histogram.", []float64{.5, 1})
+	h.observe(.25)
+	h.observe(.75)
+	h.observe(3)
+	newGaugeFunc(r, "test_depth", "Test gauge.", func() float64 { return 4 })
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	want := `# HELP test_total Test counter.
+# TYPE test_total counter
+test_total{name="a"} 2
+test_total{name="say \"hi\""} 1
+# HELP test_plain_total Unused counter.
+# TYPE test_plain_total counter
+test_plain_total 0
+# HELP test_seconds Test histogram.
+# TYPE test_seconds histogram
+test_seconds_bucket{le="0.5"} 1
+test_seconds_bucket{le="1"} 2
+test_seconds_bucket{le="+Inf"} 3
+test_seconds_sum 4
+test_seconds_count 3
+# HELP test_depth Test gauge.
+# TYPE test_depth gauge
+test_depth 4
+`
+	if got := rec.Body.String(); got != want {
+		t.Errorf("unexpected metrics:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestBotMetrics(t *testing.T) {
+	b, s := newTestBot()
+	r := &rule{Name: "links", Type: "links", Limit: 1, Count: 1, Action: "ban", Reason: "no links"}
+	if err := r.compile(); err != nil {
+		t.Fatal(err)
+	}
+	b.rules = []*rule{r}
+
+	seen, nukes, bans := messagesSeen.value(), commandsRun.value("!nuke"), ruleActions.value("links", "ban")
+	say(b, s, chatter("a"), "badword")
+	say(b, s, testMod, "!nuke badword")
+	say(b, s, testUser, "https://example.com")
+
+	if got := messagesSeen.value() - seen; got != 3 {
+		t.Errorf("expected 3 messages seen, got %v", got)
+	}
+	if got := commandsRun.value("!nuke") - nukes; got != 1 {
+		t.Errorf("expected 1 nuke command, got %v", got)
+	}
+	if got := ruleActions.value("links", "ban") - bans; got != 1 {
+		t.Errorf("expected 1 link ban, got %v", got)
+	}
+}
+
+func TestAPIMetrics(t *testing.T) {
+	f := &fakeBackend{status: http.StatusInternalServerError, body: `{"message":"down"}`}
+	c := newStrimsClient(f.start(t).URL, "secret")
+
+	failed := apiRequests.value("strims", "500")
+	if _, err := c.getProfileInfo(context.Background()); err == nil {
+		t.Fatal("expected an error")
+	}
+	if got := apiRequests.value("strims", "500") - failed; got != 1 {
+		t.Errorf("expected 1 failed request, got %v", got)
+	}
+
+	rec := httptest.NewRecorder()
+	metrics.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if !strings.Contains(rec.Body.String(), `modbot_api_request_duration_seconds_count{api="strims"}`) {
+		t.Errorf("expected api latency in metrics:\n%s", rec.Body.String())
+	}
+}
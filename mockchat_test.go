@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/MemeLabs/dggchat"
+	"github.com/gorilla/websocket"
+)
+
+// mockChat is a websocket server speaking the chat protocol. Tests script
+// users with say/whisper and assert on the actions the bot sends back.
+type mockChat struct {
+	t   *testing.T
+	srv *httptest.Server
+
+	mu      sync.Mutex
+	conns   []*websocket.Conn
+	users   []dggchat.User
+	cookies []string
+	// refuse is the number of connection attempts to reject.
+	refuse int
+	// connected gets a value for every new connection.
+	connected chan struct{}
+	received  chan action
+}
+
+func newMockChat(t *testing.T, users ...dggchat.User) *mockChat {
+	c := &mockChat{
+		t:         t,
+		users:     users,
+		connected: make(chan struct{}, 10),
+		received:  make(chan action, 100),
+	}
+	upgrader := websocket.Upgrader{}
+	c.srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.mu.Lock()
+		if c.refuse > 0 {
+			c.refuse--
+			c.mu.Unlock()
+			http.Error(w, "down for maintenance", http.StatusServiceUnavailable)
+			return
+		}
+		c.mu.Unlock()
+
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade: %v", err)
+			return
+		}
+
+		c.mu.Lock()
+		c.conns = append(c.conns, ws)
+		c.cookies = append(c.cookies, r.Header.Get("Cookie"))
+		names := dggchat.Names{Connections: len(c.users), Users: c.users}
+		c.mu.Unlock()
+
+		c.write(ws, "NAMES", names)
+		c.connected <- struct{}{}
+		c.read(ws)
+	}))
+	t.Cleanup(c.close)
+	return c
+}
+
+// url returns the ws url of the server.
+func (c *mockChat) url() url.URL {
+	u, _ := url.Parse(c.srv.URL)
+	u.Scheme = "ws"
+	u.Path = "/ws"
+	return *u
+}
+
+func (c *mockChat) close() {
+	c.mu.Lock()
+	for _, ws := range c.conns {
+		ws.Close()
+	}
+	c.mu.Unlock()
+	c.srv.Close()
+}
+
+// read turns everything a client sends into actions.
+func (c *mockChat) read(ws *websocket.Conn) {
+	for {
+		_, msg, err := ws.ReadMessage()
+		if err != nil {
+			return
+		}
+		parts := strings.SplitN(string(msg), " ", 2)
+		if len(parts) != 2 {
+			c.t.Errorf("malformed frame %q", msg)
+			continue
+		}
+
+		var data struct {
+			Nick     string `json:"nick"`
+			Data     string `json:"data"`
+			Reason   string `json:"reason"`
+			Duration int64  `json:"duration"`
+		}
+		if err := json.Unmarshal([]byte(parts[1]), &data); err != nil {
+			c.t.Errorf("malformed frame %q: %v", msg, err)
+			continue
+		}
+
+		a := action{Type: parts[0], Duration: time.Duration(data.Duration)}
+		switch a.Type {
+		case "MSG":
+			a.Message = data.Data
+		case "PRIVMSG":
+			a.Nick, a.Message = data.Nick, data.Data
+		case "BAN":
+			a.Nick, a.Message = data.Nick, data.Reason
+		default:
+			a.Nick = data.Data
+		}
+		c.received <- a
+	}
+}
+
+func (c *mockChat) write(ws *websocket.Conn, msgType string, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// writes fail if a test dropped the connection, that's fine.
+	ws.WriteMessage(websocket.TextMessage, []byte(fmt.Sprintf("%s %s", msgType, data)))
+}
+
+// broadcast sends a frame to all connections.
+func (c *mockChat) broadcast(msgType string, v interface{}) {
+	c.mu.Lock()
+	conns := append([]*websocket.Conn{}, c.conns...)
+	c.mu.Unlock()
+	for _, ws := range conns {
+		c.write(ws, msgType, v)
+	}
+}
+
+type mockMessage struct {
+	Nick      string   `json:"nick"`
+	Features  []string `json:"features"`
+	Timestamp int64    `json:"timestamp"`
+	Data      string   `json:"data"`
+}
+
+func (c *mockChat) say(user dggchat.User, msg string) {
+	c.broadcast("MSG", mockMessage{user.Nick, user.Features, time.Now().UnixNano() / int64(time.Millisecond), msg})
+}
+
+func (c *mockChat) whisper(user dggchat.User, msg string) {
+	c.broadcast("PRIVMSG", struct {
+		mockMessage
+		MessageID int `json:"messageid"`
+	}{mockMessage{user.Nick, user.Features, time.Now().UnixNano() / int64(time.Millisecond), msg}, 1})
+}
+
+// event sends a MUTE, UNMUTE, BAN or UNBAN of target by sender.
+func (c *mockChat) event(msgType string, sender dggchat.User, target string) {
+	c.broadcast(msgType, mockMessage{sender.Nick, sender.Features, time.Now().UnixNano() / int64(time.Millisecond), target})
+}
+
+func (c *mockChat) errorMessage(e string) {
+	c.broadcast("ERR", e)
+}
+
+// drop closes all connections and rejects the next refuse attempts to
+// reconnect, as a server restart would.
+func (c *mockChat) drop(refuse int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.refuse = refuse
+	for _, ws := range c.conns {
+		ws.Close()
+	}
+	c.conns = nil
+}
+
+func (c *mockChat) waitConnected() {
+	c.t.Helper()
+	select {
+	case <-c.connected:
+	case <-time.After(5 * time.Second):
+		c.t.Fatal("bot didn't connect")
+	}
+}
+
+// expect waits for the next action the bot sends and checks its type and
+// nick.
+func (c *mockChat) expect(actionType, nick string) action {
+	c.t.Helper()
+	select {
+	case a := <-c.received:
+		if a.Type != actionType || a.Nick != nick {
+			c.t.Fatalf("expected %s %s, got %s", actionType, nick, a)
+		}
+		return a
+	case <-time.After(5 * time.Second):
+		c.t.Fatalf("timed out waiting for %s %s", actionType, nick)
+	}
+	return action{}
+}
+
+// expectNothing checks that the bot stays quiet for a moment.
+func (c *mockChat) expectNothing() {
+	c.t.Helper()
+	select {
+	case a := <-c.received:
+		c.t.Fatalf("expected nothing, got %s", a)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
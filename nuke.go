@@ -0,0 +1,398 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MemeLabs/dggchat"
+)
+
+// keep PMs well below the chat's message length limit
+const maxMessageLength = 400
+
+// parseNukeArgs splits "[duration] [window] phrase" into its parts, missing
+// durations are the given defaults. Leading durations are only consumed while
+// a phrase remains, so "!nuke 5m" nukes "5m".
+func parseNukeArgs(args string, duration, window time.Duration) (time.Duration, time.Duration, string) {
+	phrase := strings.TrimSpace(args)
+
+	for i := 0; i < 2; i++ {
+		parts := strings.SplitN(phrase, " ", 2)
+		if len(parts) != 2 {
+			break
+		}
+		d, err := time.ParseDuration(parts[0])
+		if err != nil || d <= 0 {
+			break
+		}
+		if i == 0 {
+			duration = d
+		} else {
+			window = d
+		}
+		phrase = strings.TrimSpace(parts[1])
+	}
+	return duration, window, phrase
+}
+
+// findNukeVictims returns the nicks of all non-mods that said something
+// matching within the window, in order of appearance and without duplicates.
+func (b *bot) findNukeVictims(match func(string) bool, cmd dggchat.Message, window time.Duration) []string {
+	cutoff := b.now().Add(-window)
+	seen := map[string]bool{}
+	victims := []string{}
+
+	for _, msg := range b.log {
+		// the log is preallocated, skip empty entries.
+		if msg.Sender.Nick == "" || msg.Timestamp.Before(cutoff) {
+			continue
+		}
+		// don't nuke mods.
+		if b.roleOf(msg.Sender) >= roleMod {
+			continue
+		}
+		if seen[strings.ToLower(msg.Sender.Nick)] || !match(msg.Message) {
+			continue
+		}
+
+		seen[strings.ToLower(msg.Sender.Nick)] = true
+		victims = append(victims, msg.Sender.Nick)
+		eventLog.infof("Nuking '%s' because of message '%s' with nuke '%s'\n",
+			msg.Sender.Nick, msg.Message, cmd.Message)
+	}
+	return victims
+}
+
+// !nuke [duration] [window] str, !nukeregex [duration] [window] regexp
+func (b *bot) nuke(m dggchat.Message, s chatSession) {
+	parts := strings.SplitN(m.Message, " ", 2)
+	if len(parts) <= 1 {
+		return
+	}
+
+	mute, window, badstr := parseNukeArgs(parts[1], b.cfg.NukeDuration.Duration, b.cfg.NukeWindow.Duration)
+	if badstr == "" {
+		return
+	}
+
+	n := &nukeEntry{
+		Issuer:   m.Sender.Nick,
+		Pattern:  badstr,
+		Regex:    parts[0] == "!nukeregex",
+		Time:     b.now(),
+		Duration: duration{mute},
+	}
+	if err := n.compile(); err != nil {
+		s.SendPrivateMessage(m.Sender.Nick, fmt.Sprintf("regexp error: %s", err.Error()))
+		return
+	}
+
+	b.fireNuke(n, b.findNukeVictims(n.match, m, window), m.Sender.Nick, s)
+}
+
+// fireNuke mutes the victims, arms the compiled nuke and reports to chat.
+// The list of victims is sent to notify via PM, unless it is empty.
+func (b *bot) fireNuke(n *nukeEntry, victims []string, notify string, s chatSession) {
+	n.Victims = victims
+	b.addNukeEntry(n)
+
+	// nuke mutes are not escalated so they can be undone exactly, but they
+	// count towards the offences until undone.
+	source := fmt.Sprintf("nuke #%d", n.ID)
+	b.audit(auditEntry{Action: "nuke", Actor: n.Issuer, Target: n.Pattern, Duration: n.Duration,
+		Reason: fmt.Sprintf("#%d, %d %s", n.ID, len(victims), plural(len(victims), "victim")), Origin: b.origin})
+	for _, nick := range victims {
+		b.recordOffence(nick, n.Pattern, source)
+		s.SendMute(nick, n.Duration.Duration)
+		b.audit(auditEntry{Action: "mute", Actor: n.Issuer, Target: nick, Duration: n.Duration,
+			Reason: n.Pattern, Origin: source})
+	}
+	eventLog.infof("nuke: '%s' by '%s' muted %d for %s\n",
+		n.Pattern, n.Issuer, len(victims), n.Duration.Duration)
+	nukeVictims.observe(float64(len(victims)))
+
+	b.sendMessage(fmt.Sprintf("nuked %d %s for %s %s (#%d)",
+		len(victims), plural(len(victims), "user"), formatDuration(n.Duration.Duration), b.cfg.OminousEmote, n.ID), s)
+	if len(victims) > 0 && notify != "" {
+		for _, msg := range joinMessages("nuked: ", victims, maxMessageLength) {
+			s.SendPrivateMessage(notify, msg)
+		}
+	}
+}
+
+// nukeEntry is a single nuke. It stays armed for its duration, muting anyone
+// who says the pattern, and can be undone until its last mute lapsed.
+type nukeEntry struct {
+	ID      int    `json:"id"`
+	Issuer  string `json:"issuer"`
+	Pattern string `json:"pattern"`
+	Regex   bool   `json:"regex"`
+	// IgnoreCase makes a plain pattern match regardless of case.
+	IgnoreCase bool `json:"ignore_case,omitempty"`
+	// Raid is set for nukes of detected raids, they also mute variants.
+	Raid     bool      `json:"raid,omitempty"`
+	Time     time.Time `json:"time"`
+	LastMute time.Time `json:"last_mute"`
+	Duration duration  `json:"duration"`
+	Victims  []string  `json:"victims"`
+
+	match func(string) bool
+}
+
+// compile prepares the matcher for the nuke's pattern.
+func (n *nukeEntry) compile() error {
+	if !n.Regex && n.IgnoreCase {
+		pattern := strings.ToLower(n.Pattern)
+		n.match = func(msg string) bool { return strings.Contains(strings.ToLower(msg), pattern) }
+		return nil
+	}
+	if !n.Regex {
+		pattern := n.Pattern
+		n.match = func(msg string) bool { return strings.Contains(msg, pattern) }
+		return nil
+	}
+	re, err := regexp.Compile(n.Pattern)
+	if err != nil {
+		return err
+	}
+	n.match = re.MatchString
+	return nil
+}
+
+func (n *nukeEntry) armed(now time.Time) bool {
+	return now.Before(n.Time.Add(n.Duration.Duration))
+}
+
+func (n *nukeEntry) expired(now time.Time) bool {
+	last := n.Time
+	if n.LastMute.After(last) {
+		last = n.LastMute
+	}
+	return now.After(last.Add(n.Duration.Duration))
+}
+
+func (n *nukeEntry) hasVictim(nick string) bool {
+	for _, v := range n.Victims {
+		if strings.EqualFold(v, nick) {
+			return true
+		}
+	}
+	return false
+}
+
+// describe formats the nuke for !nukes as seen at now.
+func (n *nukeEntry) describe(now time.Time) string {
+	kind := "nuke"
+	if n.Regex {
+		kind = "nukeregex"
+	}
+	if n.armed(now) {
+		kind = "armed " + kind
+	}
+	return fmt.Sprintf("#%d %s '%s' by %s %s ago, %d %s",
+		n.ID, kind, n.Pattern, n.Issuer, formatDuration(now.Sub(n.Time).Truncate(time.Second)),
+		len(n.Victims), plural(len(n.Victims), "victim"))
+}
+
+func (b *bot) addNukeEntry(n *nukeEntry) {
+	b.nextNukeID++
+	n.ID = b.nextNukeID
+	b.nukes = append(b.nukes, n)
+}
+
+// pruneNukes drops all nukes whose mutes have lapsed.
+func (b *bot) pruneNukes() {
+	now := b.now()
+	active := b.nukes[:0]
+	for _, n := range b.nukes {
+		if !n.expired(now) {
+			active = append(active, n)
+		}
+	}
+	b.nukes = active
+}
+
+// undoNukes removes the given nukes and the offences they caused and unmutes
+// their victims, unless they are still covered by another nuke. Returns the
+// unmuted nicks.
+func (b *bot) undoNukes(undo []*nukeEntry, s chatSession) []string {
+	remove := map[*nukeEntry]bool{}
+	for _, n := range undo {
+		remove[n] = true
+	}
+
+	remaining := []*nukeEntry{}
+	stillMuted := map[string]bool{}
+	for _, n := range b.nukes {
+		if remove[n] {
+			continue
+		}
+		remaining = append(remaining, n)
+		for _, nick := range n.Victims {
+			stillMuted[strings.ToLower(nick)] = true
+		}
+	}
+	b.nukes = remaining
+
+	unmuted := []string{}
+	for _, n := range undo {
+		source := fmt.Sprintf("nuke #%d", n.ID)
+		for _, nick := range n.Victims {
+			b.forgetOffences(nick, source)
+			if stillMuted[strings.ToLower(nick)] {
+				continue
+			}
+			stillMuted[strings.ToLower(nick)] = true
+			s.SendUnmute(nick)
+			unmuted = append(unmuted, nick)
+		}
+	}
+	return unmuted
+}
+
+// auditAegis records undone nukes and the resulting unmutes.
+func (b *bot) auditAegis(target string, unmuted []string, actor string) {
+	origin := b.origin
+	b.audit(auditEntry{Action: "aegis", Actor: actor, Target: target,
+		Reason: fmt.Sprintf("unmuted %d %s", len(unmuted), plural(len(unmuted), "user")), Origin: origin})
+	for _, nick := range unmuted {
+		b.audit(auditEntry{Action: "unmute", Actor: actor, Target: nick, Reason: "aegis " + target, Origin: origin})
+	}
+}
+
+// enforceNukes mutes non-mods saying something matching a still armed nuke.
+func (b *bot) enforceNukes(m dggchat.Message, s chatSession) {
+	if b.roleOf(m.Sender) >= roleMod {
+		return
+	}
+
+	now := b.now()
+	for _, n := range b.nukes {
+		if !n.armed(now) || n.match == nil || !n.match(m.Message) {
+			continue
+		}
+
+		b.muteLate(n, m, s)
+		// one mute is enough.
+		return
+	}
+}
+
+// muteLate mutes the sender of m with the armed nuke n.
+func (b *bot) muteLate(n *nukeEntry, m dggchat.Message, s chatSession) {
+	eventLog.infof("Nuking '%s' because of message '%s' with active nuke #%d '%s'\n",
+		m.Sender.Nick, m.Message, n.ID, n.Pattern)
+	source := fmt.Sprintf("nuke #%d", n.ID)
+	b.recordOffence(m.Sender.Nick, n.Pattern, source)
+	s.SendMute(m.Sender.Nick, n.Duration.Duration)
+	b.audit(auditEntry{Action: "mute", Actor: n.Issuer, Target: m.Sender.Nick, Duration: n.Duration,
+		Reason: n.Pattern, Origin: source})
+	nukeLateVictims.inc()
+	n.LastMute = b.now()
+	if !n.hasVictim(m.Sender.Nick) {
+		n.Victims = append(n.Victims, m.Sender.Nick)
+	}
+}
+
+// !nukes - list nukes which can still be undone
+func (b *bot) listNukes(m dggchat.Message, s chatSession) {
+	b.pruneNukes()
+	if len(b.nukes) == 0 {
+		s.SendPrivateMessage(m.Sender.Nick, "no active nukes")
+		return
+	}
+	for _, n := range b.nukes {
+		s.SendPrivateMessage(m.Sender.Nick, n.describe(b.now()))
+	}
+}
+
+// !aegis [id] - undo the given or the latest nuke
+func (b *bot) aegis(m dggchat.Message, s chatSession) {
+	b.pruneNukes()
+	if len(b.nukes) == 0 {
+		s.SendPrivateMessage(m.Sender.Nick, "no active nukes")
+		return
+	}
+
+	n := b.nukes[len(b.nukes)-1]
+	parts := strings.Fields(m.Message)
+	if len(parts) >= 2 {
+		id, err := strconv.Atoi(strings.TrimPrefix(parts[1], "#"))
+		if err != nil {
+			s.SendPrivateMessage(m.Sender.Nick, fmt.Sprintf("invalid nuke id '%s'", parts[1]))
+			return
+		}
+		n = b.findNuke(id)
+		if n == nil {
+			s.SendPrivateMessage(m.Sender.Nick, fmt.Sprintf("nuke #%d not found or expired", id))
+			return
+		}
+	}
+
+	unmuted := b.undoNukes([]*nukeEntry{n}, s)
+	b.auditAegis(fmt.Sprintf("#%d", n.ID), unmuted, m.Sender.Nick)
+	eventLog.infof("aegis: nuke #%d '%s' undone by '%s', unmuted %d\n",
+		n.ID, n.Pattern, m.Sender.Nick, len(unmuted))
+	b.sendMessage(fmt.Sprintf("undid nuke #%d, unmuted %d %s",
+		n.ID, len(unmuted), plural(len(unmuted), "user")), s)
+}
+
+// !aegisall - undo all past nukes
+func (b *bot) aegisAll(m dggchat.Message, s chatSession) {
+	b.pruneNukes()
+	count := len(b.nukes)
+	unmuted := b.undoNukes(b.nukes, s)
+	b.auditAegis("all", unmuted, m.Sender.Nick)
+	eventLog.infof("aegisall: %d nukes undone by '%s', unmuted %d\n",
+		count, m.Sender.Nick, len(unmuted))
+	b.sendMessage(fmt.Sprintf("undid %d %s, unmuted %d %s",
+		count, plural(count, "nuke"), len(unmuted), plural(len(unmuted), "user")), s)
+}
+
+func (b *bot) findNuke(id int) *nukeEntry {
+	for _, n := range b.nukes {
+		if n.ID == id {
+			return n
+		}
+	}
+	return nil
+}
+
+func plural(n int, word string) string {
+	if n == 1 {
+		return word
+	}
+	return word + "s"
+}
+
+// formatDuration is humanizeDuration, but handles durations below a minute.
+func formatDuration(d time.Duration) string {
+	if d < time.Minute {
+		return d.String()
+	}
+	return humanizeDuration(d)
+}
+
+// joinMessages joins words with ", " into as few messages as possible, each
+// no longer than max (unless a single word is longer) and starting with prefix.
+func joinMessages(prefix string, words []string, max int) []string {
+	out := []string{}
+	cur := ""
+	for _, w := range words {
+		if cur != "" && len(prefix)+len(cur)+len(w)+2 > max {
+			out = append(out, prefix+cur)
+			cur = ""
+		}
+		if cur != "" {
+			cur += ", "
+		}
+		cur += w
+	}
+	if cur != "" {
+		out = append(out, prefix+cur)
+	}
+	return out
+}
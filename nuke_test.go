@@ -0,0 +1,94 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MemeLabs/dggchat"
+)
+
+func TestParseNukeArgs(t *testing.T) {
+	tests := []struct {
+		args     string
+		duration time.Duration
+		window   time.Duration
+		phrase   string
+	}{
+		{"badword", defaultNukeDuration, defaultNukeWindow, "badword"},
+		{"1h badword", time.Hour, defaultNukeWindow, "badword"},
+		{"1h 30s bad word", time.Hour, 30 * time.Second, "bad word"},
+		{"1h 30s 5m", time.Hour, 30 * time.Second, "5m"},
+		{"5m", defaultNukeDuration, defaultNukeWindow, "5m"},
+	}
+	for _, tt := range tests {
+		d, w, p := parseNukeArgs(tt.args, defaultNukeDuration, defaultNukeWindow)
+		if d != tt.duration || w != tt.window || p != tt.phrase {
+			t.Errorf("parseNukeArgs(%q) = %s, %s, %q", tt.args, d, w, p)
+		}
+	}
+}
+
+func TestFindNukeVictims(t *testing.T) {
+	b := newBot(10)
+	now := time.Now()
+	mod := dggchat.User{Nick: "mod", Features: []string{"moderator"}}
+	say := func(nick, msg string, age time.Duration) {
+		b.log = append(b.log[1:], dggchat.Message{
+			Sender:    dggchat.User{Nick: nick},
+			Message:   msg,
+			Timestamp: now.Add(-age),
+		})
+	}
+
+	say("old", "badword", time.Hour)
+	say("a", "badword", time.Minute)
+	say("b", "good", time.Minute)
+	say("a", "badword again", 0)
+	say("c", "more badword", 0)
+	cmd := dggchat.Message{Sender: mod, Message: "!nuke badword", Timestamp: now}
+	b.log = append(b.log[1:], cmd)
+
+	match := func(msg string) bool { return strings.Contains(msg, "badword") }
+	victims := b.findNukeVictims(match, cmd, 5*time.Minute)
+	if want := []string{"a", "c"}; !reflect.DeepEqual(victims, want) {
+		t.Errorf("victims = %v, want %v", victims, want)
+	}
+}
+
+func TestJoinMessages(t *testing.T) {
+	msgs := joinMessages("x: ", []string{"aaaa", "bbbb", "cccc"}, 13)
+	if want := []string{"x: aaaa, bbbb", "x: cccc"}; !reflect.DeepEqual(msgs, want) {
+		t.Errorf("joinMessages = %q, want %q", msgs, want)
+	}
+}
+
+func TestPruneNukes(t *testing.T) {
+	b := newBot(0)
+	b.addNukeEntry(&nukeEntry{Time: time.Now().Add(-time.Hour), Duration: duration{time.Minute}})
+	b.addNukeEntry(&nukeEntry{Time: time.Now(), Duration: duration{time.Minute}})
+	b.pruneNukes()
+
+	if len(b.nukes) != 1 || b.nukes[0].ID != 2 {
+		t.Errorf("expected only nuke #2 to remain, got %v", b.nukes)
+	}
+}
+
+func TestNukeArmed(t *testing.T) {
+	start := time.Now().Add(-time.Hour)
+	n := &nukeEntry{Pattern: "bad", Time: start, Duration: duration{2 * time.Hour}}
+	if err := n.compile(); err != nil {
+		t.Fatal(err)
+	}
+	if !n.armed(time.Now()) || !n.match("so bad") || n.match("good") {
+		t.Error("expected nuke to be armed and match 'bad'")
+	}
+
+	// a late mute keeps the nuke undoable after it disarmed.
+	n.LastMute = time.Now()
+	later := start.Add(2*time.Hour + time.Minute)
+	if n.armed(later) || n.expired(later) {
+		t.Error("expected nuke to be disarmed but not expired")
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/MemeLabs/dggchat"
+)
+
+// offence is a single recorded punishment of a user.
+type offence struct {
+	Time   time.Time `json:"time"`
+	Reason string    `json:"reason"`
+	// Source is what caused the offence, e.g. "!mute by mod" or "rule short messages".
+	Source string `json:"source"`
+}
+
+// ladderStep is the punishment for the n-th offence within the decay time.
+type ladderStep struct {
+	// Action is either mute or ban.
+	Action   string   `json:"action"`
+	Duration duration `json:"duration"`
+}
+
+// ladderConfig escalates punishments for repeat offenders. Offences older
+// than Decay are forgotten, the last step applies to all further offences.
+type ladderConfig struct {
+	Steps []ladderStep `json:"steps"`
+	Decay duration     `json:"decay"`
+}
+
+func defaultLadderConfig() *ladderConfig {
+	return &ladderConfig{
+		Steps: []ladderStep{
+			{Action: "mute"},
+			{Action: "mute", Duration: duration{30 * time.Minute}},
+			{Action: "mute", Duration: duration{2 * time.Hour}},
+			{Action: "ban", Duration: duration{24 * time.Hour}},
+		},
+		Decay: duration{24 * time.Hour},
+	}
+}
+
+func (s ladderStep) String() string {
+	if s.Duration.Duration <= 0 {
+		return s.Action + " for the default duration"
+	}
+	return fmt.Sprintf("%s for %s", s.Action, formatDuration(s.Duration.Duration))
+}
+
+func (c *ladderConfig) validate() error {
+	if len(c.Steps) == 0 {
+		return fmt.Errorf("ladder: at least one step is needed")
+	}
+	if c.Decay.Duration <= 0 {
+		return fmt.Errorf("ladder: decay has to be positive")
+	}
+	for i, step := range c.Steps {
+		switch step.Action {
+		case "mute", "ban":
+		default:
+			return fmt.Errorf("ladder: step %d has unknown action '%s'", i+1, step.Action)
+		}
+	}
+	return nil
+}
+
+// step returns the punishment for a user with n offences, including the current one.
+func (c *ladderConfig) step(n int) ladderStep {
+	if n > len(c.Steps) {
+		n = len(c.Steps)
+	}
+	if n < 1 {
+		n = 1
+	}
+	return c.Steps[n-1]
+}
+
+// activeOffences returns the offences of nick which did not decay yet.
+func (b *bot) activeOffences(nick string) []offence {
+	key := strings.ToLower(nick)
+	cutoff := b.now().Add(-b.ladder.Decay.Duration)
+	active := []offence{}
+	for _, o := range b.offences[key] {
+		if o.Time.After(cutoff) {
+			active = append(active, o)
+		}
+	}
+	if len(active) == 0 {
+		delete(b.offences, key)
+	} else {
+		b.offences[key] = active
+	}
+	return active
+}
+
+// pruneOffences forgets all decayed offences.
+func (b *bot) pruneOffences() {
+	for nick := range b.offences {
+		b.activeOffences(nick)
+	}
+}
+
+// recordOffence remembers an offence and returns the number of active offences.
+func (b *bot) recordOffence(nick, reason, source string) int {
+	if b.offences == nil {
+		b.offences = map[string][]offence{}
+	}
+	active := append(b.activeOffences(nick), offence{
+		Time:   b.now(),
+		Reason: reason,
+		Source: source,
+	})
+	b.offences[strings.ToLower(nick)] = active
+	return len(active)
+}
+
+// forgetOffences drops the offences of nick caused by source, e.g. an undone
+// nuke.
+func (b *bot) forgetOffences(nick, source string) {
+	kept := []offence{}
+	for _, o := range b.activeOffences(nick) {
+		if o.Source != source {
+			kept = append(kept, o)
+		}
+	}
+	if len(kept) == 0 {
+		delete(b.offences, strings.ToLower(nick))
+	} else {
+		b.offences[strings.ToLower(nick)] = kept
+	}
+}
+
+// punish records an offence and mutes or bans nick according to the ladder.
+// The mute lasts at least the requested duration, 0 is the server default.
+// Returns the action taken and its duration.
+func (b *bot) punish(nick, reason, source string, requested time.Duration, s chatSession) (string, time.Duration) {
+	n := b.recordOffence(nick, reason, source)
+	step := b.ladder.step(n)
+
+	d := step.Duration.Duration
+	if requested > d {
+		d = requested
+	}
+
+	eventLog.infof("punish: %s '%s' for %s (offence %d, %s)\n", step.Action, nick, d, n, source)
+	if step.Action == "ban" {
+		s.SendBan(nick, reason, d, false)
+		return "ban", d
+	}
+	s.SendMute(nick, d)
+	return "mute", d
+}
+
+// !offences user - list a user's recent offences via PM
+func (b *bot) listOffences(m dggchat.Message, s chatSession) {
+	parts := strings.Fields(m.Message)
+	if len(parts) < 2 {
+		return
+	}
+	nick := parts[1]
+
+	active := b.activeOffences(nick)
+	if len(active) == 0 {
+		s.SendPrivateMessage(m.Sender.Nick, fmt.Sprintf("%s has no recent offences", nick))
+		return
+	}
+
+	next := b.ladder.step(len(active) + 1)
+	s.SendPrivateMessage(m.Sender.Nick, fmt.Sprintf("%s has %d %s in the last %s, next is %s",
+		nick, len(active), plural(len(active), "offence"), formatDuration(b.ladder.Decay.Duration), next))
+
+	lines := []string{}
+	for _, o := range active {
+		lines = append(lines, fmt.Sprintf("%s ago %s (%s)",
+			formatDuration(b.now().Sub(o.Time).Truncate(time.Second)), o.Source, o.Reason))
+	}
+	for _, msg := range joinMessages("", lines, maxMessageLength) {
+		s.SendPrivateMessage(m.Sender.Nick, msg)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLadderStep(t *testing.T) {
+	c := defaultLadderConfig()
+	tests := []struct {
+		offences int
+		action   string
+		duration time.Duration
+	}{
+		{0, "mute", 0},
+		{1, "mute", 0},
+		{2, "mute", 30 * time.Minute},
+		{4, "ban", 24 * time.Hour},
+		{10, "ban", 24 * time.Hour},
+	}
+	for _, tt := range tests {
+		step := c.step(tt.offences)
+		if step.Action != tt.action || step.Duration.Duration != tt.duration {
+			t.Errorf("step(%d) = %s, want %s %s", tt.offences, step, tt.action, tt.duration)
+		}
+	}
+}
+
+func TestRecordOffence(t *testing.T) {
+	b := newBot(0)
+	b.offences["user"] = []offence{{Time: time.Now().Add(-48 * time.Hour), Source: "decayed"}}
+
+	if n := b.recordOffence("User", "spam", "test"); n != 1 {
+		t.Errorf("expected decayed offence to be forgotten, got %d offences", n)
+	}
+	if n := b.recordOffence("user", "spam", "test"); n != 2 {
+		t.Errorf("expected 2 offences, got %d", n)
+	}
+
+	b.offences["old"] = []offence{{Time: time.Now().Add(-48 * time.Hour)}}
+	b.pruneOffences()
+	if _, ok := b.offences["old"]; ok {
+		t.Error("expected decayed user to be pruned")
+	}
+}
+
+func TestListOffencesClock(t *testing.T) {
+	b, s := newTestBot()
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	b.now = func() time.Time { return now }
+	b.recordOffence("a", "spam", "test")
+	now = now.Add(90 * time.Minute)
+
+	say(b, s, testMod, "!offences a")
+	pms := s.find("PRIVMSG")
+	if len(pms) != 2 || pms[1].Message != "1hour 30mins ago test (spam)" {
+		t.Errorf("expected the age by the bot's clock, got %v", pms)
+	}
+}
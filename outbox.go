@@ -0,0 +1,292 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultSendInterval keeps us below the chat's message throttle.
+	defaultSendInterval = 500 * time.Millisecond
+	// maxSendRetries is how often a message is resent before it's dropped.
+	maxSendRetries    = 3
+	coalesceSeparator = " | "
+)
+
+// throttleBackoff is the pause after the chat reported a throttle, a var so
+// tests can shorten it.
+var throttleBackoff = 2 * time.Second
+
+// ackWindow is how long after sending the chat's errors are blamed on the
+// sent message, a var so tests can shorten it.
+var ackWindow = 2 * time.Second
+
+// reconnectPoll is how often the outbox checks for a new session while the
+// chat reconnects, a var so tests can shorten it.
+var reconnectPoll = 100 * time.Millisecond
+
+// outMessage is a queued message, nick is empty for public messages. For
+// moderation actions act is MUTE, UNMUTE, BAN or UNBAN, nick the target and
+// text the ban reason.
+type outMessage struct {
+	nick     string
+	text     string
+	act      string
+	duration time.Duration
+	banip    bool
+	retries  int
+	// vary forces a variation, e.g. after the chat reported a duplicate.
+	vary bool
+}
+
+// outbox queues chat messages and PMs and sends them no faster than the
+// chat's throttle allows. Messages from the same handler call are coalesced
+// into as few messages as possible.
+type outbox struct {
+	interval time.Duration
+	wake     chan struct{}
+
+	mu sync.Mutex
+	// current returns the connection's session, nil while reconnecting.
+	// Without it messages go to sess, the last wrapped session.
+	current func() chatSession
+	sess    chatSession
+	pending []outMessage
+	queue   []outMessage
+	// inflight is the last message sent, errors are reported without
+	// reference to a message, so we blame it for ackWindow after sentAt.
+	inflight *outMessage
+	sentAt   time.Time
+	// lastText and lastVaried describe the last public message, prev* the
+	// one before, in case the chat rejects the last.
+	lastText   string
+	lastVaried bool
+	prevText   string
+	prevVaried bool
+	lastSent   time.Time
+	notBefore  time.Time
+}
+
+func newOutbox(interval time.Duration) *outbox {
+	return &outbox{
+		interval: interval,
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+// queuedSession sends messages, PMs and moderation actions through the
+// outbox, so they share its rate limit.
+type queuedSession struct {
+	chatSession
+	o *outbox
+}
+
+func (q queuedSession) SendMessage(message string) error {
+	q.o.enqueue(outMessage{text: message})
+	return nil
+}
+
+func (q queuedSession) SendPrivateMessage(nick string, message string) error {
+	q.o.enqueue(outMessage{nick: nick, text: message})
+	return nil
+}
+
+func (q queuedSession) SendMute(nick string, duration time.Duration) error {
+	q.o.enqueue(outMessage{act: "MUTE", nick: nick, duration: duration})
+	return nil
+}
+
+func (q queuedSession) SendUnmute(nick string) error {
+	q.o.enqueue(outMessage{act: "UNMUTE", nick: nick})
+	return nil
+}
+
+func (q queuedSession) SendBan(nick string, reason string, duration time.Duration, banip bool) error {
+	q.o.enqueue(outMessage{act: "BAN", nick: nick, text: reason, duration: duration, banip: banip})
+	return nil
+}
+
+func (q queuedSession) SendUnban(nick string) error {
+	q.o.enqueue(outMessage{act: "UNBAN", nick: nick})
+	return nil
+}
+
+// wrap returns a session queueing messages, which are sent through s once
+// flushed.
+func (o *outbox) wrap(s chatSession) chatSession {
+	o.mu.Lock()
+	o.sess = s
+	o.mu.Unlock()
+	return queuedSession{chatSession: s, o: o}
+}
+
+// follow sends messages through the sessions returned by current from now on,
+// so they never go to a session closed by a reconnect.
+func (o *outbox) follow(current func() chatSession) {
+	o.mu.Lock()
+	o.current = current
+	o.mu.Unlock()
+}
+
+func (o *outbox) enqueue(m outMessage) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.pending = append(o.pending, m)
+}
+
+// depth returns the number of messages waiting to be sent.
+func (o *outbox) depth() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return len(o.pending) + len(o.queue)
+}
+
+// flush coalesces the pending messages and hands them to the sender.
+func (o *outbox) flush() {
+	o.mu.Lock()
+	o.queue = append(o.queue, coalesce(o.pending)...)
+	o.pending = nil
+	o.mu.Unlock()
+
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+// coalesce joins consecutive messages to the same target, as long as they
+// fit into a single message. Moderation actions are kept as they are.
+func coalesce(msgs []outMessage) []outMessage {
+	out := []outMessage{}
+	for _, m := range msgs {
+		if n := len(out); n > 0 && m.act == "" && out[n-1].act == "" && out[n-1].nick == m.nick &&
+			len(out[n-1].text)+len(coalesceSeparator)+len(m.text) <= maxMessageLength {
+			out[n-1].text += coalesceSeparator + m.text
+			continue
+		}
+		out = append(out, m)
+	}
+	return out
+}
+
+// run sends queued messages until the process exits.
+func (o *outbox) run() {
+	for range o.wake {
+		for o.sendNext() {
+		}
+	}
+}
+
+// sendNext sends the next message once allowed, it returns false if the
+// queue is empty.
+func (o *outbox) sendNext() bool {
+	o.mu.Lock()
+	if len(o.queue) == 0 {
+		o.mu.Unlock()
+		return false
+	}
+	next := o.lastSent.Add(o.interval)
+	if o.notBefore.After(next) {
+		next = o.notBefore
+	}
+	if wait := time.Until(next); wait > 0 {
+		o.mu.Unlock()
+		time.Sleep(wait)
+		return true
+	}
+	sess := o.sess
+	if o.current != nil {
+		sess = o.current()
+	}
+	if sess == nil {
+		// keep the queue until reconnected.
+		o.mu.Unlock()
+		time.Sleep(reconnectPoll)
+		return true
+	}
+
+	m := o.queue[0]
+	o.queue = o.queue[1:]
+	text := m.text
+	public := m.act == "" && m.nick == ""
+	if public {
+		varied := m.vary || (m.text == o.lastText && !o.lastVaried)
+		if varied {
+			text += " ."
+		}
+		o.prevText, o.prevVaried = o.lastText, o.lastVaried
+		o.lastText, o.lastVaried = m.text, varied
+	}
+	o.inflight = &m
+	o.lastSent = time.Now()
+	o.sentAt = o.lastSent
+	o.mu.Unlock()
+
+	var err error
+	switch {
+	case m.act == "MUTE":
+		err = sess.SendMute(m.nick, m.duration)
+	case m.act == "UNMUTE":
+		err = sess.SendUnmute(m.nick)
+	case m.act == "BAN":
+		err = sess.SendBan(m.nick, m.text, m.duration, m.banip)
+	case m.act == "UNBAN":
+		err = sess.SendUnban(m.nick)
+	case public:
+		err = sess.SendMessage(text)
+	default:
+		err = sess.SendPrivateMessage(m.nick, text)
+	}
+	if err != nil {
+		eventLog.errorf("send error: %s\n", err.Error())
+		o.retry(false)
+	}
+	return true
+}
+
+// retry requeues the last message in front of the queue, unless it was sent
+// longer than ackWindow ago and went through.
+func (o *outbox) retry(vary bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	m := o.inflight
+	o.inflight = nil
+	if m == nil || time.Since(o.sentAt) > ackWindow {
+		return
+	}
+	if m.act == "" && m.nick == "" {
+		o.lastText, o.lastVaried = o.prevText, o.prevVaried
+	}
+	if m.retries >= maxSendRetries {
+		if m.act != "" {
+			eventLog.warnf("dropping %s of %s after %d retries\n", m.act, m.nick, m.retries)
+		} else {
+			eventLog.warnf("dropping message after %d retries: %s\n", m.retries, m.text)
+		}
+		return
+	}
+	m.retries++
+	// only messages can be duplicates.
+	m.vary = m.vary || (vary && m.act == "")
+	o.queue = append([]outMessage{*m}, o.queue...)
+}
+
+// onError resends the last message if the chat rejected it.
+func (o *outbox) onError(e string) {
+	switch strings.ToLower(e) {
+	case "throttled":
+		o.mu.Lock()
+		o.notBefore = time.Now().Add(throttleBackoff)
+		o.mu.Unlock()
+		o.retry(false)
+	case "duplicate":
+		o.retry(true)
+	default:
+		return
+	}
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
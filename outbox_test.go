@@ -0,0 +1,205 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCoalesce(t *testing.T) {
+	long := strings.Repeat("x", maxMessageLength-5)
+	got := coalesce([]outMessage{
+		{text: "10 strims.gg/a"},
+		{text: "5 strims.gg/b"},
+		{nick: "mod", text: "pm 1"},
+		{nick: "mod", text: "pm 2"},
+		{text: "3 strims.gg/c"},
+		{text: long},
+	})
+	want := []outMessage{
+		{text: "10 strims.gg/a | 5 strims.gg/b"},
+		{nick: "mod", text: "pm 1 | pm 2"},
+		{text: "3 strims.gg/c"},
+		{text: long},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("message %d: got %v, want %v", i, got[i], want[i])
+		}
+	}
+}
+
+// drain sends everything queued in o.
+func drain(o *outbox) {
+	for o.sendNext() {
+	}
+}
+
+func TestOutboxBurst(t *testing.T) {
+	s := &recordingSession{}
+	o := newOutbox(20 * time.Millisecond)
+	q := o.wrap(s)
+	q.SendMessage("10 strims.gg/a")
+	q.SendMessage("5 strims.gg/b")
+	q.SendPrivateMessage("mod", "nuked: a")
+	q.SendMute("a", time.Minute)
+	q.SendMute("b", time.Minute)
+
+	if len(s.actions) != 0 {
+		t.Fatalf("nothing should be sent before the flush, got %v", s.actions)
+	}
+
+	o.flush()
+	start := time.Now()
+	drain(o)
+	if msgs := s.find("MSG"); len(msgs) != 1 || msgs[0].Message != "10 strims.gg/a | 5 strims.gg/b" {
+		t.Errorf("expected one coalesced message, got %v", msgs)
+	}
+	expectNicks(t, "pm", s.find("PRIVMSG"), "mod")
+	expectNicks(t, "mutes", s.find("MUTE"), "a", "b")
+	if elapsed := time.Since(start); elapsed < 3*20*time.Millisecond {
+		t.Errorf("expected the PM and mutes to be rate limited, took %s", elapsed)
+	}
+}
+
+func TestOutboxDedupe(t *testing.T) {
+	s := &recordingSession{}
+	o := newOutbox(0)
+	q := o.wrap(s)
+	for _, msg := range []string{"hi", "hi", "hi", "other", "hi"} {
+		q.SendMessage(msg)
+		o.flush()
+		drain(o)
+	}
+
+	got := []string{}
+	for _, a := range s.find("MSG") {
+		got = append(got, a.Message)
+	}
+	want := "hi,hi .,hi,other,hi"
+	if strings.Join(got, ",") != want {
+		t.Errorf("got %v, want %s", got, want)
+	}
+}
+
+func TestOutboxRetry(t *testing.T) {
+	old := throttleBackoff
+	throttleBackoff = 30 * time.Millisecond
+	defer func() { throttleBackoff = old }()
+
+	s := &recordingSession{}
+	o := newOutbox(0)
+	q := o.wrap(s)
+	q.SendMessage("hello")
+	o.flush()
+	drain(o)
+
+	start := time.Now()
+	o.onError("throttled")
+	drain(o)
+	if elapsed := time.Since(start); elapsed < throttleBackoff {
+		t.Errorf("expected a pause after the throttle, took %s", elapsed)
+	}
+
+	o.onError("duplicate")
+	drain(o)
+
+	// unrelated errors don't resend.
+	o.onError("needlogin")
+	drain(o)
+
+	got := []string{}
+	for _, a := range s.find("MSG") {
+		got = append(got, a.Message)
+	}
+	if want := "hello,hello,hello ."; strings.Join(got, ",") != want {
+		t.Errorf("got %v, want %s", got, want)
+	}
+
+	for i := 0; i < maxSendRetries+2; i++ {
+		o.onError("throttled")
+		o.mu.Lock()
+		o.notBefore = time.Time{}
+		o.mu.Unlock()
+		drain(o)
+	}
+	if n := len(s.find("MSG")); n != 1+maxSendRetries {
+		t.Errorf("expected %d sends before dropping, got %d", 1+maxSendRetries, n)
+	}
+}
+
+func TestOutboxRetryMute(t *testing.T) {
+	old := throttleBackoff
+	throttleBackoff = 0
+	defer func() { throttleBackoff = old }()
+
+	s := &recordingSession{}
+	o := newOutbox(0)
+	q := o.wrap(s)
+	q.SendMessage("nuking")
+	q.SendMute("a", time.Minute)
+	o.flush()
+	drain(o)
+
+	// the throttle is blamed on the mute, not the message before it.
+	o.onError("throttled")
+	drain(o)
+	expectNicks(t, "mutes", s.find("MUTE"), "a", "a")
+	if n := len(s.find("MSG")); n != 1 {
+		t.Errorf("expected the message to be sent once, got %d", n)
+	}
+}
+
+func TestOutboxStaleError(t *testing.T) {
+	old := ackWindow
+	ackWindow = 10 * time.Millisecond
+	defer func() { ackWindow = old }()
+
+	s := &recordingSession{}
+	o := newOutbox(0)
+	q := o.wrap(s)
+	q.SendMessage("hello")
+	o.flush()
+	drain(o)
+
+	time.Sleep(2 * ackWindow)
+	o.onError("throttled")
+	drain(o)
+	if n := len(s.find("MSG")); n != 1 {
+		t.Errorf("a message sent long ago shouldn't be resent, got %v", s.actions)
+	}
+}
+
+func TestOutboxReconnect(t *testing.T) {
+	old := reconnectPoll
+	reconnectPoll = time.Millisecond
+	defer func() { reconnectPoll = old }()
+
+	closed, fresh := &recordingSession{}, &recordingSession{}
+	var current chatSession
+	o := newOutbox(0)
+	o.follow(func() chatSession { return current })
+	q := o.wrap(closed)
+	q.SendMute("spammer", time.Minute)
+	q.SendMessage("hello")
+	o.flush()
+
+	// reconnecting, nothing is sent or dropped.
+	o.sendNext()
+	if o.depth() != 2 || len(closed.actions) != 0 {
+		t.Fatalf("expected the queue to wait for the reconnect, got %d queued and %v", o.depth(), closed.actions)
+	}
+
+	current = fresh
+	drain(o)
+	if len(closed.actions) != 0 {
+		t.Errorf("expected nothing to go to the old session, got %v", closed.actions)
+	}
+	expectNicks(t, "mutes", fresh.find("MUTE"), "spammer")
+	if msgs := fresh.find("MSG"); len(msgs) != 1 {
+		t.Errorf("expected the message on the new session, got %v", msgs)
+	}
+}
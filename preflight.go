@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/MemeLabs/dggchat"
+)
+
+// prereq is something commands need besides chat, checked on start.
+type prereq string
+
+const (
+	// prereqAuth is a valid cookie for the backend.
+	prereqAuth prereq = "auth"
+	// prereqAdmin is backend admin rights, e.g. for renames.
+	prereqAdmin prereq = "admin"
+	// prereqAngelthump is a valid angelthump admin token.
+	prereqAngelthump prereq = "angelthump"
+)
+
+var prereqs = []prereq{prereqAuth, prereqAdmin, prereqAngelthump}
+
+// preflight checks all prerequisites, commands needing a failed one are
+// disabled until it passes a later check, e.g. after a reload.
+func (b *bot) preflight() {
+	results := b.checkAuthPrereqs()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	results[prereqAngelthump] = b.at.checkToken()
+	b.prereqs = results
+	for _, p := range prereqs {
+		switch err := results[p]; {
+		case err == nil:
+			eventLog.infof("preflight: %s ok\n", p)
+		case err == errATUnverified:
+			eventLog.infof("preflight: %s %s\n", p, err.Error())
+		default:
+			eventLog.warnf("preflight: %s failed: %s\n", p, err.Error())
+		}
+	}
+	if disabled := b.disabledCommands(); len(disabled) > 0 {
+		eventLog.warnf("preflight: disabled %s\n", strings.Join(disabled, ", "))
+	}
+}
+
+// checkAuthPrereqs checks the cookie and returns the results of prereqAuth and
+// prereqAdmin.
+func (b *bot) checkAuthPrereqs() map[prereq]error {
+	results := map[prereq]error{}
+	info, err := b.checkAuth()
+	results[prereqAuth] = err
+	switch {
+	case err != nil:
+		results[prereqAdmin] = fmt.Errorf("not logged in")
+	case !info.IsAdmin:
+		results[prereqAdmin] = fmt.Errorf("%s is no backend admin", info.Username)
+	default:
+		results[prereqAdmin] = nil
+	}
+	return results
+}
+
+// setPrereq records the result of a check after the preflight and logs if p
+// failed or passed again. The caller holds b.mu.
+func (b *bot) setPrereq(p prereq, err error) {
+	old, checked := b.prereqs[p]
+	if b.prereqs == nil {
+		b.prereqs = map[prereq]error{}
+	}
+	b.prereqs[p] = err
+	failed := err != nil && err != errATUnverified
+	wasFailed := checked && old != nil && old != errATUnverified
+	switch {
+	case failed == wasFailed:
+	case failed:
+		eventLog.warnf("%s check failed: %s\n", p, err.Error())
+	default:
+		eventLog.infof("%s check passed again\n", p)
+	}
+}
+
+// unmet returns an error if a prerequisite of c failed. Prerequisites which
+// weren't checked, e.g. during replay, or couldn't be verified count as met.
+func (b *bot) unmet(c *command) error {
+	for _, p := range c.needs {
+		if err := b.prereqs[p]; err != nil && err != errATUnverified {
+			return fmt.Errorf("%s check failed: %v", p, err)
+		}
+	}
+	return nil
+}
+
+func (b *bot) disabledCommands() []string {
+	disabled := []string{}
+	for _, c := range b.commands {
+		if b.unmet(c) != nil {
+			disabled = append(disabled, c.name)
+		}
+	}
+	return disabled
+}
+
+// !status - show the preflight results, chat connection and disabled
+// commands.
+func (b *bot) status(m dggchat.Message, s chatSession) {
+	parts := []string{}
+	for _, p := range prereqs {
+		err, checked := b.prereqs[p]
+		switch {
+		case !checked:
+			parts = append(parts, fmt.Sprintf("%s: not checked", p))
+		case err != nil:
+			parts = append(parts, fmt.Sprintf("%s: %v", p, err))
+		default:
+			parts = append(parts, fmt.Sprintf("%s: ok", p))
+		}
+	}
+
+	st := b.health.status()
+	if st.Connected {
+		parts = append(parts, fmt.Sprintf("chat: connected (last message %s ago)", st.SinceLastMessage))
+	} else {
+		parts = append(parts, fmt.Sprintf("chat: down for %s", st.DownFor))
+	}
+	if disabled := b.disabledCommands(); len(disabled) > 0 {
+		parts = append(parts, "disabled: "+strings.Join(disabled, " "))
+	}
+
+	for _, msg := range joinMessages("", parts, maxMessageLength) {
+		b.sendMessage(msg, s)
+	}
+}
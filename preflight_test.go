@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestPreflightDisablesCommands(t *testing.T) {
+	b, s := newTestBot()
+	f := &fakeBackend{status: http.StatusOK, body: `{"username":"bot","is_admin":false}`}
+	b.strims = newStrimsClient(f.start(t).URL, "secret")
+	b.at = newAngelthumpClient("", "")
+	b.preflight()
+
+	if b.nick != "bot" {
+		t.Errorf("expected the nick from the profile, got %q", b.nick)
+	}
+	say(b, s, testAdmin, "!rename a b")
+	expectMessage(t, s, "!rename is disabled, admin check failed: bot is no backend admin")
+	say(b, s, testAdmin, "!drop test spam")
+	expectMessage(t, s, "!drop is disabled, angelthump check failed: no angelthump admin token set")
+	s.take()
+
+	say(b, s, testMod, "!status")
+	msgs := s.find("MSG")
+	if len(msgs) != 1 {
+		t.Fatalf("expected one status message, got %v", msgs)
+	}
+	for _, want := range []string{"auth: ok", "admin: bot is no backend admin", "chat: down", "disabled: !modify !rename !drop !undrop"} {
+		if !strings.Contains(msgs[0].Message, want) {
+			t.Errorf("expected %q in %q", want, msgs[0].Message)
+		}
+	}
+}
+
+func TestCheckATToken(t *testing.T) {
+	f := &fakeBackend{status: http.StatusOK}
+	c := newAngelthumpClient(f.start(t).URL, "token")
+	if err := c.checkToken(); err != errATUnverified {
+		t.Errorf("expected the token to be unverified, got %v", err)
+	}
+	if f.path != "" {
+		t.Errorf("checking the token shouldn't send requests, got %s", f.path)
+	}
+
+	b, s := newTestBot()
+	b.at = c
+	b.strims = newStrimsClient("", "")
+	b.preflight()
+	say(b, s, testAdmin, "!drop test spam")
+	for _, msg := range s.find("MSG") {
+		if strings.Contains(msg.Message, "disabled") {
+			t.Errorf("an unverified token shouldn't disable !drop, got %q", msg.Message)
+		}
+	}
+	s.take()
+	say(b, s, testMod, "!status")
+	if msgs := s.find("MSG"); len(msgs) == 0 || !strings.Contains(msgs[0].Message, "angelthump: token set, unverified") {
+		t.Errorf("expected the token to be reported as unverified, got %v", msgs)
+	}
+	s.take()
+
+	// a rejected token disables the commands, a reload checks it again.
+	f.status, f.body = http.StatusUnauthorized, "unauthorized"
+	say(b, s, testAdmin, "!drop test spam")
+	say(b, s, testAdmin, "!drop test spam")
+	expectMessage(t, s, "!drop is disabled, angelthump check failed: status code 401")
+	s.take()
+	b.preflight()
+	f.status, f.body = http.StatusOK, `{}`
+	say(b, s, testAdmin, "!undrop test")
+	say(b, s, testMod, "!status")
+	if msgs := s.find("MSG"); len(msgs) == 0 || !strings.Contains(msgs[0].Message, "angelthump: ok") {
+		t.Errorf("expected the token to be verified by its use, got %v", msgs)
+	}
+}
+
+func TestRecheckAuth(t *testing.T) {
+	b, s := newTestBot()
+	f := &fakeBackend{status: http.StatusOK, body: `{"username":"bot","is_admin":true}`}
+	b.strims = newStrimsClient(f.start(t).URL, "secret")
+	b.at = newAngelthumpClient("", "")
+	b.preflight()
+
+	f.status, f.body = http.StatusUnauthorized, "expired"
+	b.recheckAuth()
+	say(b, s, testAdmin, "!rename a b")
+	expectMessage(t, s, "!rename is disabled, admin check failed: not logged in")
+	s.take()
+
+	f.status, f.body = http.StatusOK, `{"username":"bot","is_admin":true}`
+	b.recheckAuth()
+	say(b, s, testMod, "!status")
+	if msgs := s.find("MSG"); len(msgs) == 0 || strings.Contains(msgs[0].Message, "!rename") {
+		t.Errorf("expected !rename to be enabled again, got %v", msgs)
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/MemeLabs/dggchat"
+)
+
+// raidConfig configures the detection of many users posting the same message.
+type raidConfig struct {
+	// Users is the number of distinct users needed for a raid.
+	Users  int      `json:"users"`
+	Window duration `json:"window"`
+	// Limit is the minimum similarity of messages, between 0 and 1.
+	Limit     float64 `json:"limit"`
+	MinLength int     `json:"min_length"`
+	// Action is either alert, to PM online mods, or nuke.
+	Action   string   `json:"action"`
+	Duration duration `json:"duration"`
+}
+
+// raidAlert is a detected raid waiting for a mod to confirm it with !raid.
+type raidAlert struct {
+	Text    string
+	Victims []string
+	Time    time.Time
+}
+
+func defaultRaidConfig() *raidConfig {
+	return &raidConfig{
+		Users:     4,
+		Window:    duration{30 * time.Second},
+		Limit:     0.9,
+		MinLength: 20,
+		Action:    "alert",
+		Duration:  duration{defaultNukeDuration},
+	}
+}
+
+func (c *raidConfig) validate() error {
+	if c.Users < 2 {
+		return fmt.Errorf("raid: users has to be at least 2")
+	}
+	if c.Window.Duration <= 0 {
+		return fmt.Errorf("raid: window has to be positive")
+	}
+	switch c.Action {
+	case "alert", "nuke":
+	default:
+		return fmt.Errorf("raid: unknown action '%s'", c.Action)
+	}
+	if c.Duration.Duration <= 0 {
+		c.Duration.Duration = defaultNukeDuration
+	}
+	return nil
+}
+
+// findRaiders returns the distinct non-mods who posted something similar to
+// text within the window, newest first.
+func (b *bot) findRaiders(text string, now time.Time) []string {
+	cfg := b.raid
+	norm := normalizeMessage(text)
+	cutoff := now.Add(-cfg.Window.Duration)
+	seen := map[string]bool{}
+	raiders := []string{}
+
+	for i := len(b.log) - 1; i >= 0; i-- {
+		msg := b.log[i]
+		if msg.Sender.Nick == "" || msg.Timestamp.Before(cutoff) {
+			break
+		}
+		if b.roleOf(msg.Sender) >= roleMod || seen[strings.ToLower(msg.Sender.Nick)] {
+			continue
+		}
+		other := normalizeMessage(msg.Message)
+		// the length difference alone may rule out a match, skip the expensive check.
+		if maxSimilarity(utf8.RuneCountInString(norm), utf8.RuneCountInString(other)) < cfg.Limit ||
+			similarity(norm, other) < cfg.Limit {
+			continue
+		}
+		seen[strings.ToLower(msg.Sender.Nick)] = true
+		raiders = append(raiders, msg.Sender.Nick)
+	}
+	return raiders
+}
+
+// maxSimilarity is the highest possible similarity of strings of length a and b.
+func maxSimilarity(a, b int) float64 {
+	if a == b {
+		return 1
+	}
+	if a < b {
+		a, b = b, a
+	}
+	return float64(b) / float64(a)
+}
+
+// detectRaid notices many users posting the same message and either nukes it
+// or alerts online mods.
+func (b *bot) detectRaid(m dggchat.Message, s chatSession) {
+	if b.raid == nil || b.roleOf(m.Sender) >= roleMod || len(m.Message) < b.raid.MinLength {
+		return
+	}
+
+	now := b.now()
+	for _, n := range b.nukes {
+		if n.armed(now) && n.match != nil && n.match(m.Message) {
+			// already taken care of.
+			return
+		}
+	}
+
+	// variants of a raid that was nuked already are muted by that nuke.
+	norm := normalizeMessage(m.Message)
+	for _, n := range b.nukes {
+		if n.Raid && n.armed(now) &&
+			similarity(normalizeMessage(n.Pattern), norm) >= b.raid.Limit {
+			b.muteLate(n, m, s)
+			return
+		}
+	}
+
+	raiders := b.findRaiders(m.Message, now)
+	if len(raiders) < b.raid.Users {
+		return
+	}
+
+	// don't alert twice for the same raid, just collect the new raiders.
+	if p := b.pendingRaid; p != nil && now.Sub(p.Time) < b.cfg.RaidConfirmTimeout.Duration &&
+		similarity(normalizeMessage(p.Text), normalizeMessage(m.Message)) >= b.raid.Limit {
+		p.Victims = mergeNicks(p.Victims, raiders)
+		return
+	}
+
+	eventLog.infof("raid: %d users posted '%s'\n", len(raiders), m.Message)
+
+	if b.raid.Action == "nuke" {
+		n := b.newRaidNuke("raid detection", m.Message)
+		if err := n.compile(); err != nil {
+			eventLog.infof("raid: %s\n", err.Error())
+			return
+		}
+		b.fireNuke(n, raiders, "", s)
+		return
+	}
+
+	b.pendingRaid = &raidAlert{Text: m.Message, Victims: raiders, Time: now}
+	alert := fmt.Sprintf("possible raid, %d users posted '%s' - nuke it with !raid",
+		len(raiders), truncate(m.Message, 80))
+	for _, u := range s.GetUsers() {
+		if b.roleOf(u) >= roleMod {
+			s.SendPrivateMessage(u.Nick, alert)
+		}
+	}
+}
+
+// !raid - confirm the pending raid alert and nuke it
+func (b *bot) confirmRaid(m dggchat.Message, s chatSession) {
+	p := b.pendingRaid
+	if p == nil || b.now().Sub(p.Time) > b.cfg.RaidConfirmTimeout.Duration {
+		s.SendPrivateMessage(m.Sender.Nick, "no pending raid")
+		return
+	}
+	b.pendingRaid = nil
+
+	n := b.newRaidNuke(m.Sender.Nick, p.Text)
+	if err := n.compile(); err != nil {
+		eventLog.infof("raid: %s\n", err.Error())
+		return
+	}
+	b.fireNuke(n, p.Victims, m.Sender.Nick, s)
+}
+
+// newRaidNuke returns a nuke for the raid message text, it ignores case
+// and mutes variants of the message while armed.
+func (b *bot) newRaidNuke(issuer, text string) *nukeEntry {
+	mute := duration{defaultNukeDuration}
+	if b.raid != nil {
+		mute = b.raid.Duration
+	}
+	return &nukeEntry{
+		Issuer:     issuer,
+		Pattern:    strings.TrimSpace(text),
+		IgnoreCase: true,
+		Raid:       true,
+		Time:       b.now(),
+		Duration:   mute,
+	}
+}
+
+// mergeNicks appends nicks from add that are not in nicks yet.
+func mergeNicks(nicks, add []string) []string {
+	seen := map[string]bool{}
+	for _, nick := range nicks {
+		seen[strings.ToLower(nick)] = true
+	}
+	for _, nick := range add {
+		if !seen[strings.ToLower(nick)] {
+			seen[strings.ToLower(nick)] = true
+			nicks = append(nicks, nick)
+		}
+	}
+	return nicks
+}
+
+func truncate(s string, max int) string {
+	r := []rune(s)
+	if len(r) <= max {
+		return s
+	}
+	return string(r[:max]) + "..."
+}
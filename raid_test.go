@@ -0,0 +1,93 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/MemeLabs/dggchat"
+)
+
+func TestFindRaiders(t *testing.T) {
+	b := newBot(10)
+	now := time.Now()
+	say := func(nick, msg string, age time.Duration, features ...string) {
+		b.log = append(b.log[1:], dggchat.Message{
+			Sender:    dggchat.User{Nick: nick, Features: features},
+			Message:   msg,
+			Timestamp: now.Add(-age),
+		})
+	}
+
+	pasta := "this chat is now owned by the meme raiders"
+	say("old", pasta, time.Hour)
+	say("a", pasta, 20*time.Second)
+	say("b", "unrelated message", 15*time.Second)
+	say("mod", pasta, 10*time.Second, "moderator")
+	say("c", "This chat is now owned by the meme raiders!!", 5*time.Second)
+	say("a", pasta, 2*time.Second)
+	say("d", pasta, 0)
+
+	raiders := b.findRaiders(pasta, now)
+	if want := []string{"d", "a", "c"}; !reflect.DeepEqual(raiders, want) {
+		t.Errorf("raiders = %v, want %v", raiders, want)
+	}
+}
+
+func TestMergeNicks(t *testing.T) {
+	got := mergeNicks([]string{"a", "B"}, []string{"b", "c"})
+	if want := []string{"a", "B", "c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("mergeNicks = %v, want %v", got, want)
+	}
+}
+
+func TestRaidAlert(t *testing.T) {
+	b, s := newTestBot()
+	pasta := "this chat is now owned by the meme raiders"
+	for _, nick := range []string{"a", "b", "c", "d"} {
+		say(b, s, chatter(nick), pasta)
+	}
+	expectNicks(t, "raid alert", s.find("PRIVMSG"), "mod")
+	s.take()
+
+	say(b, s, chatter("e"), pasta)
+	if len(s.actions) != 0 {
+		t.Errorf("expected no second alert, got %v", s.actions)
+	}
+
+	say(b, s, testMod, "!raid")
+	expectNicks(t, "raid nuke", s.find("MUTE"), "d", "c", "b", "a", "e")
+	s.take()
+
+	// variants are muted by the confirmed nuke without a new alert.
+	say(b, s, chatter("f"), "THIS CHAT IS NOW OWNED BY THE MEME RAIDERS!!")
+	expectNicks(t, "variant", s.find("MUTE"), "f")
+	if pms := s.find("PRIVMSG"); len(pms) != 0 {
+		t.Errorf("expected no new alert, got %v", pms)
+	}
+}
+
+func TestRaidNukeOnce(t *testing.T) {
+	b, s := newTestBot()
+	b.raid.Action = "nuke"
+	pasta := "this chat is now owned by the meme raiders"
+	for _, nick := range []string{"a", "b", "c", "d"} {
+		say(b, s, chatter(nick), pasta)
+	}
+	expectNicks(t, "raid nuke", s.find("MUTE"), "d", "c", "b", "a")
+	s.take()
+
+	// variants are muted by the armed nuke instead of firing new ones.
+	say(b, s, chatter("e"), "THIS CHAT IS NOW OWNED BY THE MEME RAIDERS")
+	say(b, s, chatter("f"), "This chat is now owned by the meme raiders!!")
+	expectNicks(t, "late mutes", s.find("MUTE"), "e", "f")
+	if msgs := s.find("MSG"); len(msgs) != 0 {
+		t.Errorf("expected no further nukes, got %v", msgs)
+	}
+	if len(b.nukes) != 1 {
+		t.Errorf("expected a single nuke, got %d", len(b.nukes))
+	}
+	if n := len(b.offences["e"]); n != 1 {
+		t.Errorf("expected one offence for e, got %d", n)
+	}
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/MemeLabs/dggchat"
+)
+
+// backoff bounds between reconnect attempts, vars so tests can shorten them.
+var (
+	reconnectMinBackoff = time.Second
+	reconnectMaxBackoff = time.Minute
+)
+
+// chatConn is a chat connection which is reopened after socket errors. The
+// dggchat lib reconnects on its own, but forever and without jitter, so we
+// close broken sessions and open a fresh one instead.
+type chatConn struct {
+	b    *bot
+	down chan struct{}
+	// stop is closed by Close, done once supervise returned.
+	stop chan struct{}
+	done chan struct{}
+
+	mu sync.Mutex
+	// cookie, chatURL and maxFailures can be changed by a reload and are
+	// used from the next reconnect on.
+	cookie  string
+	chatURL url.URL
+	// maxFailures is the number of failed reconnects in a row after which
+	// fatal is called, 0 retries forever.
+	maxFailures int
+	// fatal is called if reconnecting failed maxFailures times.
+	fatal func(err error)
+	// sess is nil while reconnecting.
+	sess   *dggchat.Session
+	closed bool
+	// outages and downtime count the lost connections so far.
+	outages  int
+	downtime time.Duration
+	// downSince is when the current outage started.
+	downSince time.Time
+}
+
+// connect opens a chat session to chatURL with all handlers registered.
+func (b *bot) connect(cookie string, chatURL url.URL, maxFailures int) (*chatConn, error) {
+	c := &chatConn{
+		b:           b,
+		cookie:      cookie,
+		chatURL:     chatURL,
+		maxFailures: maxFailures,
+		fatal:       b.fatal,
+		down:        make(chan struct{}, 1),
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	sess, err := c.open()
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.sess = sess
+	c.mu.Unlock()
+	go c.supervise()
+	return c, nil
+}
+
+// configure changes the settings used for reconnecting.
+func (c *chatConn) configure(cookie string, chatURL url.URL, maxFailures int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cookie, c.chatURL, c.maxFailures = cookie, chatURL, maxFailures
+}
+
+func (c *chatConn) open() (*dggchat.Session, error) {
+	c.mu.Lock()
+	cookie, chatURL := c.cookie, c.chatURL
+	c.mu.Unlock()
+
+	// TODO dggchat lib isn't flexible with the cookie name, workaround...
+	dgg, err := dggchat.New(";jwt=" + cookie)
+	if err != nil {
+		return nil, err
+	}
+
+	b := c.b
+	dgg.AddMessageHandler(b.onMessage)
+	dgg.AddErrorHandler(b.onError)
+	dgg.AddMuteHandler(b.onMute)
+	dgg.AddUnmuteHandler(b.onUnmute)
+	dgg.AddBanHandler(b.onBan)
+	dgg.AddUnbanHandler(b.onUnban)
+	dgg.AddSocketErrorHandler(c.onSocketError)
+	dgg.AddPMHandler(b.onPMHandler)
+	dgg.SetURL(chatURL)
+
+	if err := dgg.Open(); err != nil {
+		return nil, err
+	}
+	return dgg, nil
+}
+
+// fatal saves the state and exits, so an orchestrator can restart the bot.
+func (b *bot) fatal(err error) {
+	if err := b.saveState(); err != nil {
+		eventLog.errorf("error saving state: %s\n", err.Error())
+	}
+	eventLog.errorf("giving up: %s\n", err.Error())
+	os.Exit(1)
+}
+
+// connStats describes the connection's health.
+type connStats struct {
+	connected bool
+	// outages and downtime count the lost connections so far.
+	outages  int
+	downtime time.Duration
+	// downSince is when the current outage started.
+	downSince time.Time
+}
+
+func (c *chatConn) stats() connStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return connStats{c.sess != nil, c.outages, c.downtime, c.downSince}
+}
+
+// session returns the current session, nil while reconnecting.
+func (c *chatConn) session() chatSession {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.sess == nil {
+		return nil
+	}
+	return c.sess
+}
+
+// Close closes the connection for good and waits for reconnects to stop.
+func (c *chatConn) Close() error {
+	// hold the lock, so socket errors caused by closing see closed.
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	close(c.stop)
+	var err error
+	if c.sess != nil {
+		err = c.sess.Close()
+	}
+	c.mu.Unlock()
+
+	<-c.done
+	return err
+}
+
+func (c *chatConn) isClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
+// onSocketError is called from the session's listen loop, which ends after it.
+func (c *chatConn) onSocketError(err error, s *dggchat.Session) {
+	eventLog.warnf("socket error: '%s'\n", err.Error())
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return
+	}
+	if c.sess == s {
+		c.sess = nil
+		c.downSince = time.Now()
+	}
+	c.mu.Unlock()
+
+	// stops the lib from reconnecting, the session is never used again.
+	s.Close()
+	select {
+	case c.down <- struct{}{}:
+	default:
+	}
+}
+
+// supervise opens a new session whenever the current one went down.
+func (c *chatConn) supervise() {
+	defer close(c.done)
+	for {
+		select {
+		case <-c.down:
+		case <-c.stop:
+			return
+		}
+		if c.isClosed() {
+			return
+		}
+		c.mu.Lock()
+		c.outages++
+		outage := c.outages
+		c.mu.Unlock()
+
+		start := time.Now()
+		eventLog.warnf("chat connection lost, outage #%d\n", outage)
+		for attempt := 1; ; attempt++ {
+			select {
+			case <-time.After(backoff(attempt)):
+			case <-c.stop:
+				return
+			}
+			sess, err := c.open()
+			if err == nil {
+				reconnectAttempts.inc("ok")
+				c.mu.Lock()
+				c.sess = sess
+				c.downSince = time.Time{}
+				c.downtime += time.Since(start)
+				closed := c.closed
+				c.mu.Unlock()
+				if closed {
+					sess.Close()
+					return
+				}
+				eventLog.infof("reconnected after %s and %d %s\n",
+					time.Since(start).Truncate(time.Millisecond), attempt, plural(attempt, "attempt"))
+				c.b.resync()
+				break
+			}
+			reconnectAttempts.inc("failed")
+			eventLog.warnf("reconnect attempt %d failed: %s\n", attempt, err.Error())
+			c.mu.Lock()
+			fatal, maxFailures := c.fatal, c.maxFailures
+			c.mu.Unlock()
+			if maxFailures > 0 && attempt >= maxFailures {
+				fatal(fmt.Errorf("chat unreachable after %d reconnect attempts: %v", attempt, err))
+				return
+			}
+		}
+	}
+}
+
+// backoff returns the exponential wait before the n-th attempt with up to
+// 50% jitter, so restarted bots don't hammer the server in lockstep.
+func backoff(attempt int) time.Duration {
+	d := reconnectMinBackoff
+	for i := 1; i < attempt && d < reconnectMaxBackoff; i++ {
+		d *= 2
+	}
+	if d > reconnectMaxBackoff {
+		d = reconnectMaxBackoff
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// resync drops state which may have lapsed during an outage. The user list
+// is resent by the server on connect.
+func (b *bot) resync() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.pruneNukes()
+	b.pruneOffences()
+	if b.pendingRaid != nil && b.now().Sub(b.pendingRaid.Time) > b.cfg.RaidConfirmTimeout.Duration {
+		b.pendingRaid = nil
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/MemeLabs/dggchat"
+)
+
+// logTimeLayout is the timestamp prefix of the standard logger.
+const logTimeLayout = "2006/01/02 15:04:05"
+
+// replayLine is a single JSONL replay entry, in the chat's own MSG format.
+type replayLine struct {
+	Nick      string   `json:"nick"`
+	Features  []string `json:"features"`
+	Timestamp int64    `json:"timestamp"` // unix millis
+	Data      string   `json:"data"`
+	// Private replays the line as PM to the bot.
+	Private bool `json:"private"`
+}
+
+// parseReplayLine parses a line of the bot's chat log
+// ("2006/01/02 15:04:05 nick: message" or "... [#] PM: nick: message") or a
+// JSON object. ok is false for lines that aren't chat messages, e.g. the
+// bot's own log output.
+func parseReplayLine(line string) (rl replayLine, ok bool, err error) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return rl, false, nil
+	}
+
+	if strings.HasPrefix(line, "{") {
+		if err := json.Unmarshal([]byte(line), &rl); err != nil {
+			return rl, false, err
+		}
+		return rl, rl.Nick != "", nil
+	}
+
+	if len(line) > len(logTimeLayout) {
+		if t, err := time.ParseInLocation(logTimeLayout, line[:len(logTimeLayout)], time.Local); err == nil {
+			rl.Timestamp = t.UnixNano() / int64(time.Millisecond)
+			line = strings.TrimSpace(line[len(logTimeLayout):])
+		}
+	}
+	if strings.HasPrefix(line, "[#] PM: ") {
+		rl.Private = true
+		line = strings.TrimPrefix(line, "[#] PM: ")
+	} else if strings.HasPrefix(line, "[") {
+		return rl, false, nil
+	}
+
+	parts := strings.SplitN(line, ": ", 2)
+	if len(parts) != 2 || parts[0] == "" || strings.Contains(parts[0], " ") {
+		return rl, false, nil
+	}
+	rl.Nick, rl.Data = parts[0], parts[1]
+	return rl, true, nil
+}
+
+// replay feeds recorded chat through the bot with a fake clock and writes
+// every action it would take to w. Nicks in mods are treated as moderators.
+func (b *bot) replay(r io.Reader, w io.Writer, mods map[string]bool) error {
+	now := time.Time{}
+	b.now = func() time.Time { return now }
+
+	s := &recordingSession{onAction: func(a action) {
+		fmt.Fprintf(w, "%s %s\n", now.Format(logTimeLayout), a)
+	}}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		rl, ok, err := parseReplayLine(scanner.Text())
+		if err != nil {
+			return fmt.Errorf("line %d: %v", n, err)
+		}
+		if !ok {
+			continue
+		}
+
+		// lines without a timestamp happen at the time of the last one.
+		if rl.Timestamp != 0 {
+			now = time.Unix(0, rl.Timestamp*int64(time.Millisecond))
+		}
+		user := dggchat.User{Nick: rl.Nick, Features: rl.Features}
+		if mods[strings.ToLower(rl.Nick)] {
+			user.Features = append(user.Features, "moderator")
+		}
+
+		if rl.Private {
+			b.handlePM(dggchat.PrivateMessage{User: user, Message: rl.Data, Timestamp: now}, s)
+			continue
+		}
+		s.addUser(user)
+		b.handleMessage(dggchat.Message{Sender: user, Message: rl.Data, Timestamp: now}, s)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	eventLog.infof("replayed until %s\n", now.Format(logTimeLayout))
+	return nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseReplayLine(t *testing.T) {
+	tests := []struct {
+		line    string
+		ok      bool
+		nick    string
+		data    string
+		private bool
+	}{
+		{"2026/10/18 12:00:01 a: hello: world", true, "a", "hello: world", false},
+		{"2026/10/18 12:00:01 [#] PM: mod: !nukes", true, "mod", "!nukes", true},
+		{"2026/10/18 12:00:01 [##] Restart", false, "", "", false},
+		{"b: no timestamp", true, "b", "no timestamp", false},
+		{"not a message", false, "", "", false},
+		{`{"nick":"c","features":["moderator"],"timestamp":1000,"data":"!nuke x"}`, true, "c", "!nuke x", false},
+		{"", false, "", "", false},
+	}
+	for _, tt := range tests {
+		rl, ok, err := parseReplayLine(tt.line)
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", tt.line, err)
+			continue
+		}
+		if ok != tt.ok || rl.Nick != tt.nick || rl.Data != tt.data || rl.Private != tt.private {
+			t.Errorf("%q: got %+v, %v", tt.line, rl, ok)
+		}
+	}
+
+	if _, _, err := parseReplayLine("{broken"); err == nil {
+		t.Error("expected an error for broken JSON")
+	}
+}
+
+func TestReplay(t *testing.T) {
+	b := newBot(250)
+	b.registerDefaults()
+
+	chatlog := strings.Join([]string{
+		"2026/10/18 12:00:00 [##] Restart",
+		"2026/10/18 12:00:01 a: buy badword now",
+		"2026/10/18 12:00:03 somemod: !nuke 1m badword",
+		"2026/10/18 12:00:30 b: late badword",
+		// the nuke is no longer armed on the fake clock.
+		"2026/10/18 12:05:00 c: badword again",
+	}, "\n")
+
+	var out bytes.Buffer
+	if err := b.replay(strings.NewReader(chatlog), &out, map[string]bool{"somemod": true}); err != nil {
+		t.Fatal(err)
+	}
+
+	got := out.String()
+	for _, want := range []string{
+		"2026/10/18 12:00:03 MUTE a 1m0s\n",
+		"2026/10/18 12:00:30 MUTE b 1m0s\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("expected %q in output:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "MUTE c") {
+		t.Errorf("expired nuke shouldn't mute:\n%s", got)
+	}
+
+	want := time.Date(2026, 10, 18, 12, 5, 0, 0, time.Local)
+	if !b.now().Equal(want) {
+		t.Errorf("expected the clock at %s, got %s", want, b.now())
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/MemeLabs/dggchat"
+)
+
+// role is the minimum privilege a user needs to run a command.
+type role int
+
+const (
+	roleEveryone role = iota
+	// roleTrusted users are exempt from spam rules and may use PM commands.
+	roleTrusted
+	roleMod
+	roleAdmin
+)
+
+var roleNames = []string{"everyone", "trusted", "mod", "admin"}
+
+func (r role) String() string {
+	if r < 0 || int(r) >= len(roleNames) {
+		return fmt.Sprintf("role(%d)", int(r))
+	}
+	return roleNames[r]
+}
+
+func parseRole(name string) (role, error) {
+	for i, n := range roleNames {
+		if strings.EqualFold(n, name) {
+			return role(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown role %q, use one of %s", name, strings.Join(roleNames, ", "))
+}
+
+func (r role) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+func (r *role) UnmarshalText(b []byte) error {
+	v, err := parseRole(string(b))
+	if err != nil {
+		return err
+	}
+	*r = v
+	return nil
+}
+
+// roleConfig maps chat features and nicks to roles, users get the highest
+// role of all matches.
+type roleConfig struct {
+	Features map[string]role `json:"features"`
+	// Users are lowercase nicks, edited with !perms.
+	Users map[string]role `json:"users"`
+}
+
+func defaultRoleConfig() *roleConfig {
+	return &roleConfig{
+		Features: map[string]role{
+			dggchat.FeatureAdministrator: roleAdmin,
+			dggchat.FeatureModerator:     roleMod,
+		},
+		Users: map[string]role{},
+	}
+}
+
+func loadRoles(path string) (*roleConfig, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := roleConfig{}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if cfg.Features == nil {
+		cfg.Features = defaultRoleConfig().Features
+	}
+	users := map[string]role{}
+	for nick, r := range cfg.Users {
+		users[strings.ToLower(nick)] = r
+	}
+	cfg.Users = users
+	return &cfg, nil
+}
+
+func (c *roleConfig) save(path string) error {
+	data, err := json.MarshalIndent(c, "", "\t")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// roleOf returns the role of user from its features and the allowlist.
+func (b *bot) roleOf(user dggchat.User) role {
+	r := roleEveryone
+	for _, f := range user.Features {
+		if fr, ok := b.roles.Features[f]; ok && fr > r {
+			r = fr
+		}
+	}
+	if ur, ok := b.roles.Users[strings.ToLower(user.Nick)]; ok && ur > r {
+		r = ur
+	}
+	return r
+}
+
+// !perms [username [role]] - show or set bot specific roles.
+func (b *bot) perms(m dggchat.Message, s chatSession) {
+	parts := strings.Fields(m.Message)
+
+	switch len(parts) {
+	case 1:
+		granted := []string{}
+		for nick, r := range b.roles.Users {
+			granted = append(granted, fmt.Sprintf("%s: %s", nick, r))
+		}
+		if len(granted) == 0 {
+			b.sendMessage("no roles granted", s)
+			return
+		}
+		sort.Strings(granted)
+		for _, msg := range joinMessages("roles: ", granted, maxMessageLength) {
+			b.sendMessage(msg, s)
+		}
+
+	case 2:
+		nick := strings.ToLower(parts[1])
+		r, ok := b.roles.Users[nick]
+		if !ok {
+			b.sendMessage(fmt.Sprintf("%s has no granted role", parts[1]), s)
+			return
+		}
+		b.sendMessage(fmt.Sprintf("%s is %s", parts[1], r), s)
+
+	default:
+		nick := strings.ToLower(parts[1])
+		r, err := parseRole(parts[2])
+		if err != nil {
+			b.sendMessage(err.Error(), s)
+			return
+		}
+		if r == roleEveryone {
+			delete(b.roles.Users, nick)
+		} else {
+			b.roles.Users[nick] = r
+		}
+		eventLog.infof("perms: %s set %s to %s\n", m.Sender.Nick, nick, r)
+
+		if b.cfg.Roles != "" {
+			if err := b.roles.save(b.cfg.Roles); err != nil {
+				eventLog.errorf("failed saving roles: %s\n", err.Error())
+				b.sendMessage("failed saving roles, check logs", s)
+				return
+			}
+		}
+		b.sendMessage(fmt.Sprintf("%s is now %s", parts[1], r), s)
+	}
+}
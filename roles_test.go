@@ -0,0 +1,91 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/MemeLabs/dggchat"
+)
+
+func TestRoleOf(t *testing.T) {
+	b := newBot(0)
+	b.roles.Features["vip"] = roleTrusted
+	b.roles.Users["helper"] = roleMod
+
+	tests := []struct {
+		user dggchat.User
+		want role
+	}{
+		{dggchat.User{Nick: "someone"}, roleEveryone},
+		{dggchat.User{Nick: "someone", Features: []string{"vip"}}, roleTrusted},
+		{dggchat.User{Nick: "someone", Features: []string{"vip", "moderator"}}, roleMod},
+		{dggchat.User{Nick: "Helper"}, roleMod},
+		// the allowlist never lowers a role.
+		{dggchat.User{Nick: "helper", Features: []string{"admin"}}, roleAdmin},
+	}
+	for _, tt := range tests {
+		if got := b.roleOf(tt.user); got != tt.want {
+			t.Errorf("roleOf(%v) = %s, want %s", tt.user, got, tt.want)
+		}
+	}
+}
+
+func TestExampleRoles(t *testing.T) {
+	cfg, err := loadRoles("roles.json.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Features["protected"] != roleTrusted || cfg.Users["ilovememes"] != roleTrusted {
+		t.Errorf("unexpected roles %+v", cfg)
+	}
+
+	path := filepath.Join(t.TempDir(), "roles.json")
+	if err := ioutil.WriteFile(path, []byte(`{"users": {"a": "boss"}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadRoles(path); err == nil {
+		t.Error("expected an error for an unknown role")
+	}
+}
+
+func TestPerms(t *testing.T) {
+	dir, err := ioutil.TempDir("", "modbot-roles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	b, s := newTestBot()
+	b.cfg.Roles = filepath.Join(dir, "roles.json")
+	helper := chatter("helper")
+
+	say(b, s, testMod, "!perms helper mod")
+	if len(s.actions) != 0 {
+		t.Fatalf("!perms is for admins only, got %v", s.actions)
+	}
+
+	say(b, s, testAdmin, "!perms helper mod")
+	expectMessage(t, s, "helper is now mod")
+	s.take()
+
+	say(b, s, chatter("a"), "badword")
+	say(b, s, helper, "!nuke badword")
+	expectNicks(t, "nuke by granted mod", s.find("MUTE"), "a")
+	s.take()
+
+	saved, err := loadRoles(b.cfg.Roles)
+	if err != nil || saved.Users["helper"] != roleMod {
+		t.Fatalf("expected the grant to be saved, got %+v, %v", saved, err)
+	}
+
+	say(b, s, testAdmin, "!perms helper everyone")
+	expectMessage(t, s, "helper is now everyone")
+	s.take()
+	say(b, s, chatter("b"), "otherword")
+	say(b, s, helper, "!nuke otherword")
+	expectNicks(t, "nuke after revoke", s.find("MUTE"))
+
+	say(b, s, testAdmin, "!perms helper boss")
+	expectMessage(t, s, "unknown role")
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/MemeLabs/dggchat"
+)
+
+type commandHandler func(m dggchat.Message, s chatSession)
+
+// command describes a single chat command, e.g. "!mute".
+type command struct {
+	name    string
+	aliases []string
+	// args describes the expected arguments, e.g. "username [duration]"
+	args    string
+	example string
+	role    role
+	// needs are checked on start, the command is disabled if one failed.
+	needs []prereq
+	// public commands post to chat even if issued via PM.
+	public  bool
+	help    string
+	handler commandHandler
+}
+
+// registerCommands adds commands to the registry. Names and aliases have to
+// be unique, later registrations replace earlier ones.
+func (b *bot) registerCommands(cmds ...command) {
+	if b.commandLookup == nil {
+		b.commandLookup = map[string]*command{}
+	}
+	for i := range cmds {
+		c := &cmds[i]
+		b.commands = append(b.commands, c)
+		b.commandLookup[c.name] = c
+		for _, alias := range c.aliases {
+			b.commandLookup[alias] = c
+		}
+	}
+}
+
+// addHook adds a passive handler which sees every chat message, e.g. spam rules.
+func (b *bot) addHook(h ...commandHandler) {
+	b.hooks = append(b.hooks, h...)
+}
+
+// commandName returns the first token of a message, the command name.
+func commandName(msg string) string {
+	if i := strings.IndexAny(msg, " \t"); i >= 0 {
+		return msg[:i]
+	}
+	return msg
+}
+
+// dispatch runs the command matching the first token of the message exactly.
+// Returns true if a command (registered or static) was found.
+func (b *bot) dispatch(m dggchat.Message, s chatSession) bool {
+	name := commandName(m.Message)
+	if !strings.HasPrefix(name, "!") {
+		return false
+	}
+
+	if c, ok := b.commandLookup[name]; ok {
+		if b.roleOf(m.Sender) < c.role {
+			return true
+		}
+		if err := b.unmet(c); err != nil {
+			b.sendMessage(fmt.Sprintf("%s is disabled, %v", c.name, err), s)
+			return true
+		}
+		if p, ok := s.(pmSession); ok && c.public {
+			s = p.chatSession
+		}
+		commandsRun.inc(c.name)
+		c.handler(m, s)
+		return true
+	}
+
+	mutex.Lock()
+	response, ok := commands[name]
+	mutex.Unlock()
+	if ok {
+		commandsRun.inc(name)
+		b.sendMessage(response, s)
+	}
+	return ok
+}
+
+// shadowedCommands returns the sorted names of static commands hidden by a
+// registered command of the same name.
+func (b *bot) shadowedCommands(static map[string]string) []string {
+	shadowed := []string{}
+	for name := range static {
+		if _, ok := b.commandLookup[name]; ok {
+			shadowed = append(shadowed, name)
+		}
+	}
+	sort.Strings(shadowed)
+	return shadowed
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/MemeLabs/dggchat"
+)
+
+func TestDispatch(t *testing.T) {
+	b := newBot(10)
+	called := map[string]int{}
+	record := func(name string) commandHandler {
+		return func(m dggchat.Message, s chatSession) {
+			called[name]++
+		}
+	}
+	b.registerCommands(
+		command{name: "!mute", role: roleMod, handler: record("!mute")},
+		command{name: "!stream", aliases: []string{"!strim"}, handler: record("!stream")},
+	)
+
+	mod := dggchat.User{Nick: "mod", Features: []string{"moderator"}}
+	user := dggchat.User{Nick: "user"}
+
+	tests := []struct {
+		sender dggchat.User
+		msg    string
+		found  bool
+	}{
+		{mod, "!mute someone 10m", true},
+		{mod, "!muted", false},
+		{user, "!mute someone", true},
+		{user, "!strim", true},
+		{user, "!streamer", false},
+		{user, "hello !stream", false},
+	}
+	for _, tt := range tests {
+		if found := b.dispatch(dggchat.Message{Sender: tt.sender, Message: tt.msg}, nil); found != tt.found {
+			t.Errorf("dispatch(%q) = %v, want %v", tt.msg, found, tt.found)
+		}
+	}
+
+	if called["!mute"] != 1 {
+		t.Errorf("!mute called %d times, want 1", called["!mute"])
+	}
+	if called["!stream"] != 1 {
+		t.Errorf("!stream called %d times, want 1", called["!stream"])
+	}
+}
+
+func TestShadowedCommands(t *testing.T) {
+	b := newBot(10)
+	b.registerCommands(command{name: "!stream", aliases: []string{"!strim"}})
+	static := map[string]string{"!strim": "a", "!github": "b", "!stream": "c"}
+	if got := strings.Join(b.shadowedCommands(static), " "); got != "!stream !strim" {
+		t.Errorf("got %q, want the names of built-ins and aliases", got)
+	}
+}
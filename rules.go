@@ -1,39 +1,400 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
-	"log"
+	"io/ioutil"
+	"os"
+	"regexp"
 	"strings"
 	"time"
+	"unicode"
 
 	"github.com/MemeLabs/dggchat"
 )
 
-// Prevent repeated posting of short messages.
-func (b *bot) noShortMsgSpam(m dggchat.Message, s *dggchat.Session) {
-	// only proceed if the current message is "bad"
-	if len(m.Message) > 2 {
-		return
+var linkPattern = regexp.MustCompile(`(?i)(https?://|www\.)\S+`)
+
+// duration is a time.Duration which is (un)marshaled as a string like "10m".
+type duration struct {
+	time.Duration
+}
+
+func (d duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// UnmarshalJSON also accepts integer nanoseconds, which older state files
+// contain.
+func (d *duration) UnmarshalJSON(b []byte) error {
+	var ns int64
+	if err := json.Unmarshal(b, &ns); err == nil {
+		d.Duration = time.Duration(ns)
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	if s == "" {
+		d.Duration = 0
+		return nil
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = v
+	return nil
+}
+
+// rule is a single spam rule. A rule triggers if the current message is bad
+// and at least Count of the user's last History messages within Window are
+// bad, including the current one.
+type rule struct {
+	Name string `json:"name"`
+	// Type is one of short, repeat, similar, caps, emotes, links or rate.
+	Type string `json:"type"`
+	// Limit is the per message threshold: max length for short, similarity
+	// to the current message for similar, uppercase ratio for caps, number
+	// of emotes for emotes and links for links.
+	Limit     float64  `json:"limit"`
+	MinLength int      `json:"min_length,omitempty"`
+	Emotes    []string `json:"emotes,omitempty"`
+	Count     int      `json:"count"`
+	// WarnCount optionally warns the user once that many messages are bad,
+	// before Count is reached and Action is taken.
+	WarnCount int      `json:"warn_count,omitempty"`
+	History   int      `json:"history"`
+	Window    duration `json:"window"`
+	// Action is one of warn, mute or ban.
+	Action   string   `json:"action"`
+	Duration duration `json:"duration"`
+	Reason   string   `json:"reason"`
+
+	// isBad reports whether msg is bad, current is the newest message.
+	isBad func(msg, current string) bool
+}
+
+// ruleConfig is the content of the rules file.
+type ruleConfig struct {
+	Rules  []*rule       `json:"rules"`
+	Raid   *raidConfig   `json:"raid,omitempty"`
+	Ladder *ladderConfig `json:"ladder,omitempty"`
+}
+
+// defaultRules are used if no rules file exists.
+func defaultRules() []*rule {
+	r := &rule{
+		Name:    "short messages",
+		Type:    "short",
+		Limit:   2,
+		Count:   5,
+		History: 10,
+		Window:  duration{60 * time.Minute},
+		Action:  "mute",
+		Reason:  "too many short messages",
+	}
+	if err := r.compile(); err != nil {
+		panic(err)
+	}
+	return []*rule{r}
+}
+
+// compile validates the rule and prepares its check.
+func (r *rule) compile() error {
+	if r.Count < 1 {
+		return fmt.Errorf("rule '%s': count has to be at least 1", r.Name)
+	}
+	if r.History < r.Count {
+		r.History = r.Count
+	}
+
+	switch r.Action {
+	case "warn", "mute", "ban":
+	default:
+		return fmt.Errorf("rule '%s': unknown action '%s'", r.Name, r.Action)
+	}
+
+	switch r.Type {
+	case "short":
+		r.isBad = func(msg, _ string) bool {
+			return len(msg) <= int(r.Limit)
+		}
+	case "repeat":
+		r.isBad = func(msg, current string) bool {
+			return len(msg) >= r.MinLength && normalizeMessage(msg) == normalizeMessage(current)
+		}
+	case "similar":
+		r.isBad = func(msg, current string) bool {
+			return len(msg) >= r.MinLength &&
+				similarity(normalizeMessage(msg), normalizeMessage(current)) >= r.Limit
+		}
+	case "caps":
+		r.isBad = func(msg, _ string) bool {
+			return capsRatio(msg, r.MinLength) >= r.Limit
+		}
+	case "emotes":
+		emotes := map[string]bool{}
+		for _, e := range r.Emotes {
+			emotes[e] = true
+		}
+		r.isBad = func(msg, _ string) bool {
+			n := 0
+			for _, word := range strings.Fields(msg) {
+				if emotes[word] {
+					n++
+				}
+			}
+			return float64(n) >= r.Limit
+		}
+	case "links":
+		r.isBad = func(msg, _ string) bool {
+			return float64(len(linkPattern.FindAllString(msg, -1))) >= r.Limit
+		}
+	case "rate":
+		r.isBad = func(string, string) bool { return true }
+	default:
+		return fmt.Errorf("rule '%s': unknown type '%s'", r.Name, r.Type)
+	}
+	return nil
+}
+
+// badCount returns how many of the user's recent messages, newest first, are
+// bad. Returns 0 if the newest message is fine.
+func (r *rule) badCount(recent []dggchat.Message, now time.Time) int {
+	if len(recent) == 0 || !r.isBad(recent[0].Message, recent[0].Message) {
+		return 0
+	}
+
+	count := 0
+	for _, msg := range recent {
+		if r.Window.Duration > 0 && msg.Timestamp.Before(now.Add(-r.Window.Duration)) {
+			continue
+		}
+		if r.isBad(msg.Message, recent[0].Message) {
+			count++
+		}
+	}
+	return count
+}
+
+// triggered checks whether the rule's action has to be taken.
+func (r *rule) triggered(recent []dggchat.Message, now time.Time) bool {
+	return r.badCount(recent, now) >= r.Count
+}
+
+// warned checks whether the user has to be warned before action is taken.
+func (r *rule) warned(recent []dggchat.Message, now time.Time) bool {
+	return r.WarnCount > 0 && r.WarnCount < r.Count && r.badCount(recent, now) == r.WarnCount
+}
+
+// normalizeMessage lowercases and collapses whitespace for comparisons.
+func normalizeMessage(msg string) string {
+	return strings.Join(strings.Fields(strings.ToLower(msg)), " ")
+}
+
+// similarity returns how similar a and b are, between 0 (different) and 1
+// (equal), based on their levenshtein distance.
+func similarity(a, b string) float64 {
+	ra, rb := []rune(a), []rune(b)
+	longest := len(ra)
+	if len(rb) > longest {
+		longest = len(rb)
 	}
+	if longest == 0 {
+		return 1
+	}
+	return 1 - float64(levenshtein(ra, rb))/float64(longest)
+}
 
-	// ["time", "msg"]
-	lastmsgs := b.getLastMessages(m.Sender.Nick, 10)
-	badmsgs := []string{}
-	badmsgcount := 0
-	now := time.Now().Add(time.Duration(-60) * time.Minute)
+func levenshtein(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
 
-	// check how many of the last messages were too short and they are within the
-	// past hour.
-	for _, msg := range lastmsgs {
-		if len(msg.Message) <= 2 && now.Before(msg.Timestamp) {
-			badmsgcount++
-			badmsgs = append(badmsgs, msg.Message)
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+// capsRatio returns the ratio of uppercase letters, or 0 for messages with
+// less than minLetters letters.
+func capsRatio(msg string, minLetters int) float64 {
+	letters, upper := 0, 0
+	for _, c := range msg {
+		if unicode.IsLetter(c) {
+			letters++
+			if unicode.IsUpper(c) {
+				upper++
+			}
 		}
 	}
+	if letters == 0 || letters < minLetters {
+		return 0
+	}
+	return float64(upper) / float64(letters)
+}
 
-	if badmsgcount >= 5 {
-		log.Printf("[##] single char mute with '%s' for '%s'\n", strings.Join(badmsgs, ", "), m.Sender.Nick)
-		s.SendMute(m.Sender.Nick, -1)
-		s.SendMessage(fmt.Sprintf("%s - too many short messages", m.Sender.Nick))
+func loadRules(path string) (*ruleConfig, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg ruleConfig
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return nil, err
+	}
+	// files without a raid section keep the default raid detection,
+	// "raid": null turns it off.
+	var sections map[string]json.RawMessage
+	if err := json.Unmarshal(b, &sections); err != nil {
+		return nil, err
+	}
+	if _, ok := sections["raid"]; !ok {
+		cfg.Raid = defaultRaidConfig()
+	}
+	for _, r := range cfg.Rules {
+		if err := r.compile(); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.Raid != nil {
+		if err := cfg.Raid.validate(); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.Ladder == nil {
+		cfg.Ladder = defaultLadderConfig()
+	}
+	if err := cfg.Ladder.validate(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// watchRules reloads the rules file whenever it changes, run it in its own
+// goroutine. The file and poll interval are taken from the current config.
+func (b *bot) watchRules() {
+	b.mu.Lock()
+	path := b.cfg.Rules
+	b.mu.Unlock()
+	var lastMod time.Time
+	if fi, err := os.Stat(path); err == nil {
+		lastMod = fi.ModTime()
+	}
+
+	for {
+		b.mu.Lock()
+		poll := b.cfg.PollTime.Duration
+		b.mu.Unlock()
+		time.Sleep(poll)
+
+		b.mu.Lock()
+		if b.cfg.Rules != path {
+			// a reload switched files and loaded the new one.
+			path, lastMod = b.cfg.Rules, time.Time{}
+			if fi, err := os.Stat(path); err == nil {
+				lastMod = fi.ModTime()
+			}
+		}
+		b.mu.Unlock()
+
+		fi, err := os.Stat(path)
+		if err != nil || fi.ModTime().Equal(lastMod) {
+			continue
+		}
+		lastMod = fi.ModTime()
+
+		cfg, err := loadRules(path)
+		if err != nil {
+			eventLog.warnf("rules: keeping old rules, reload failed: %s\n", err.Error())
+			continue
+		}
+		b.mu.Lock()
+		b.rules = cfg.Rules
+		b.raid = cfg.Raid
+		b.ladder = cfg.Ladder
+		b.mu.Unlock()
+		eventLog.infof("rules: reloaded %d rules from %s\n", len(cfg.Rules), path)
+	}
+}
+
+// firstWarning tells whether the warn-only rule r didn't warn nick within its
+// window yet and remembers the warning. Without a window it warns once.
+func (b *bot) firstWarning(r *rule, nick string, now time.Time) bool {
+	for k, t := range b.ruleWarnings {
+		if r.Window.Duration > 0 && now.Sub(t) > r.Window.Duration && strings.HasPrefix(k, r.Name+"\xff") {
+			delete(b.ruleWarnings, k)
+		}
+	}
+	key := r.Name + "\xff" + strings.ToLower(nick)
+	if _, ok := b.ruleWarnings[key]; ok {
+		return false
+	}
+	b.ruleWarnings[key] = now
+	return true
+}
+
+// applyRules punishes users triggering a spam rule, at most one per message.
+func (b *bot) applyRules(m dggchat.Message, s chatSession) {
+	if b.roleOf(m.Sender) >= roleTrusted {
+		return
+	}
+
+	now := b.now()
+	for _, r := range b.rules {
+		recent := b.getLastMessages(m.Sender.Nick, r.History)
+		if r.warned(recent, now) {
+			eventLog.infof("rule '%s': warn for '%s' with '%s'\n", r.Name, m.Sender.Nick, m.Message)
+			ruleActions.inc(r.Name, "warn")
+			s.SendMessage(fmt.Sprintf("%s - %s, last warning", m.Sender.Nick, r.Reason))
+			b.audit(auditEntry{Action: "warn", Actor: "bot", Target: m.Sender.Nick,
+				Reason: r.Reason, Origin: "rule " + r.Name})
+			return
+		}
+		if !r.triggered(recent, now) {
+			continue
+		}
+		// the rule stays triggered for the whole window, warn once.
+		if r.Action == "warn" && !b.firstWarning(r, m.Sender.Nick, now) {
+			continue
+		}
+
+		eventLog.infof("rule '%s': %s for '%s' with '%s'\n", r.Name, r.Action, m.Sender.Nick, m.Message)
+		source := fmt.Sprintf("rule %s", r.Name)
+		action, d := r.Action, r.Duration.Duration
+		switch r.Action {
+		case "mute":
+			action, d = b.punish(m.Sender.Nick, r.Reason, source, r.Duration.Duration, s)
+		case "ban":
+			b.recordOffence(m.Sender.Nick, r.Reason, source)
+			s.SendBan(m.Sender.Nick, r.Reason, r.Duration.Duration, false)
+		}
+		ruleActions.inc(r.Name, action)
+		b.audit(auditEntry{Action: action, Actor: "bot", Target: m.Sender.Nick,
+			Duration: duration{d}, Reason: r.Reason, Origin: source})
+		s.SendMessage(fmt.Sprintf("%s - %s", m.Sender.Nick, r.Reason))
+		return
 	}
 }
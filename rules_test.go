@@ -0,0 +1,229 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/MemeLabs/dggchat"
+)
+
+func TestLoadExampleRules(t *testing.T) {
+	cfg, err := loadRules("rules.json.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Rules) != 7 {
+		t.Errorf("expected 7 rules, got %d", len(cfg.Rules))
+	}
+	if cfg.Raid == nil {
+		t.Error("expected raid detection to be configured")
+	}
+}
+
+func TestRuleTriggered(t *testing.T) {
+	now := time.Now()
+	// newest first, like getLastMessages.
+	history := func(msgs ...string) []dggchat.Message {
+		out := []dggchat.Message{}
+		for i, msg := range msgs {
+			out = append(out, dggchat.Message{
+				Message:   msg,
+				Timestamp: now.Add(-time.Duration(i) * time.Second),
+			})
+		}
+		return out
+	}
+
+	tests := []struct {
+		name   string
+		rule   rule
+		recent []dggchat.Message
+		want   bool
+	}{
+		{
+			"short",
+			rule{Type: "short", Limit: 2, Count: 3, Window: duration{time.Minute}, Action: "mute"},
+			history("a", "b", "long message", "c"),
+			true,
+		},
+		{
+			"short, current message is fine",
+			rule{Type: "short", Limit: 2, Count: 3, Window: duration{time.Minute}, Action: "mute"},
+			history("long message", "a", "b", "c"),
+			false,
+		},
+		{
+			"short, outside of window",
+			rule{Type: "short", Limit: 2, Count: 3, Window: duration{2 * time.Second}, Action: "mute"},
+			history("a", "b", "long message", "c"),
+			false,
+		},
+		{
+			"repeat",
+			rule{Type: "repeat", Count: 2, Action: "warn"},
+			history("Hello  World", "something", "hello world"),
+			true,
+		},
+		{
+			"similar",
+			rule{Type: "similar", Limit: 0.8, Count: 2, Action: "mute"},
+			history("buy cheap memes at memes.example", "something else", "Buy cheap  memes at memes.example!!"),
+			true,
+		},
+		{
+			"similar, too different",
+			rule{Type: "similar", Limit: 0.8, Count: 2, Action: "mute"},
+			history("buy cheap memes at memes.example", "sell expensive memes elsewhere"),
+			false,
+		},
+		{
+			"caps",
+			rule{Type: "caps", Limit: 0.8, MinLength: 5, Count: 1, Action: "warn"},
+			history("WHY IS THIS HAPPENING"),
+			true,
+		},
+		{
+			"caps, too short",
+			rule{Type: "caps", Limit: 0.8, MinLength: 5, Count: 1, Action: "warn"},
+			history("OK"),
+			false,
+		},
+		{
+			"emotes",
+			rule{Type: "emotes", Limit: 3, Emotes: []string{"PepoDance"}, Count: 1, Action: "mute"},
+			history("PepoDance PepoDance hi PepoDance"),
+			true,
+		},
+		{
+			"links",
+			rule{Type: "links", Limit: 1, Count: 2, Action: "mute"},
+			history("look https://example.com", "www.example.com"),
+			true,
+		},
+		{
+			"rate",
+			rule{Type: "rate", Count: 3, Window: duration{10 * time.Second}, Action: "mute"},
+			history("1", "2", "3"),
+			true,
+		},
+	}
+
+	for _, tt := range tests {
+		r := tt.rule
+		if err := r.compile(); err != nil {
+			t.Fatalf("%s: %s", tt.name, err)
+		}
+		if got := r.triggered(tt.recent, now); got != tt.want {
+			t.Errorf("%s: triggered = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRuleCompileErrors(t *testing.T) {
+	bad := []rule{
+		{Type: "short", Count: 0, Action: "mute"},
+		{Type: "unknown", Count: 1, Action: "mute"},
+		{Type: "short", Count: 1, Action: "explode"},
+	}
+	for _, r := range bad {
+		if err := r.compile(); err == nil {
+			t.Errorf("expected error for %+v", r)
+		}
+	}
+}
+
+func TestSimilarity(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want float64
+	}{
+		{"", "", 1},
+		{"abcd", "abcd", 1},
+		{"abcd", "abce", 0.75},
+		{"abcd", "", 0},
+		{"kitten", "sitting", 1 - 3.0/7},
+	}
+	for _, tt := range tests {
+		if got := similarity(tt.a, tt.b); got != tt.want {
+			t.Errorf("similarity(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestRuleWarned(t *testing.T) {
+	r := rule{Type: "repeat", WarnCount: 2, Count: 3, Action: "mute"}
+	if err := r.compile(); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	msg := dggchat.Message{Message: "spam", Timestamp: now}
+
+	recent := []dggchat.Message{msg}
+	for i, want := range []struct{ warned, triggered bool }{
+		{false, false},
+		{true, false},
+		{false, true},
+	} {
+		if got := r.warned(recent, now); got != want.warned {
+			t.Errorf("message %d: warned = %v, want %v", i+1, got, want.warned)
+		}
+		if got := r.triggered(recent, now); got != want.triggered {
+			t.Errorf("message %d: triggered = %v, want %v", i+1, got, want.triggered)
+		}
+		recent = append(recent, msg)
+	}
+}
+
+func TestLoadRulesRaidDefault(t *testing.T) {
+	dir, err := ioutil.TempDir("", "modbot-rules")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "rules.json")
+
+	ioutil.WriteFile(path, []byte(`{"rules": []}`), 0o644)
+	cfg, err := loadRules(path)
+	if err != nil || cfg.Raid == nil || cfg.Raid.Action != "alert" {
+		t.Errorf("expected the default raid detection without a raid section, got %+v, %v", cfg, err)
+	}
+
+	ioutil.WriteFile(path, []byte(`{"rules": [], "raid": null}`), 0o644)
+	cfg, err = loadRules(path)
+	if err != nil || cfg.Raid != nil {
+		t.Errorf("expected \"raid\": null to turn raid detection off, got %+v, %v", cfg, err)
+	}
+}
+
+func TestWarnRuleOncePerWindow(t *testing.T) {
+	b, s := newTestBot()
+	r := &rule{Name: "caps", Type: "caps", Limit: 0.8, MinLength: 15, Count: 3, History: 5,
+		Window: duration{5 * time.Minute}, Action: "warn", Reason: "please don't shout"}
+	if err := r.compile(); err != nil {
+		t.Fatal(err)
+	}
+	b.rules = []*rule{r}
+	now := time.Now()
+	b.now = func() time.Time { return now }
+	shout := func(n int) {
+		for i := 0; i < n; i++ {
+			now = now.Add(time.Second)
+			b.handleMessage(dggchat.Message{Sender: testUser, Message: "THIS IS VERY LOUD TEXT", Timestamp: now}, s)
+		}
+	}
+
+	shout(6)
+	if msgs := s.find("MSG"); len(msgs) != 1 {
+		t.Errorf("expected a single warning, got %v", msgs)
+	}
+	s.take()
+
+	now = now.Add(10 * time.Minute)
+	shout(3)
+	if msgs := s.find("MSG"); len(msgs) != 1 {
+		t.Errorf("expected another warning after the window, got %v", msgs)
+	}
+}
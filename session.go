@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MemeLabs/dggchat"
+)
+
+// chatSession is the part of *dggchat.Session the bot needs, so handlers can
+// run without a websocket.
+type chatSession interface {
+	SendMessage(message string) error
+	SendPrivateMessage(nick string, message string) error
+	SendMute(nick string, duration time.Duration) error
+	SendUnmute(nick string) error
+	SendBan(nick string, reason string, duration time.Duration, banip bool) error
+	SendUnban(nick string) error
+	GetUsers() []dggchat.User
+}
+
+var _ chatSession = (*dggchat.Session)(nil)
+
+// publicSuffix makes the bot answer a command issued via PM in chat.
+const publicSuffix = "--public"
+
+// pmSession answers commands issued via PM in kind, chat messages are sent
+// to nick as PM instead.
+type pmSession struct {
+	chatSession
+	nick string
+}
+
+func (p pmSession) SendMessage(message string) error {
+	return p.chatSession.SendPrivateMessage(p.nick, message)
+}
+
+// action is a single thing the bot sent to chat.
+type action struct {
+	// Type is one of MSG, PRIVMSG, MUTE, UNMUTE, BAN or UNBAN.
+	Type     string
+	Nick     string
+	Message  string
+	Duration time.Duration
+}
+
+func (a action) String() string {
+	switch a.Type {
+	case "MSG":
+		return fmt.Sprintf("MSG %s", a.Message)
+	case "PRIVMSG":
+		return fmt.Sprintf("PRIVMSG %s: %s", a.Nick, a.Message)
+	case "MUTE":
+		return fmt.Sprintf("MUTE %s %s", a.Nick, a.Duration)
+	case "BAN":
+		return fmt.Sprintf("BAN %s %s %s", a.Nick, a.Duration, a.Message)
+	}
+	return fmt.Sprintf("%s %s", a.Type, a.Nick)
+}
+
+// recordingSession is an in-memory chatSession which records all actions.
+type recordingSession struct {
+	sync.Mutex
+	actions []action
+	users   []dggchat.User
+	// onAction is optionally called for every action.
+	onAction func(a action)
+}
+
+func (r *recordingSession) record(a action) error {
+	r.Lock()
+	r.actions = append(r.actions, a)
+	r.Unlock()
+	if r.onAction != nil {
+		r.onAction(a)
+	}
+	return nil
+}
+
+func (r *recordingSession) SendMessage(message string) error {
+	return r.record(action{Type: "MSG", Message: message})
+}
+
+func (r *recordingSession) SendPrivateMessage(nick string, message string) error {
+	return r.record(action{Type: "PRIVMSG", Nick: nick, Message: message})
+}
+
+func (r *recordingSession) SendMute(nick string, duration time.Duration) error {
+	return r.record(action{Type: "MUTE", Nick: nick, Duration: duration})
+}
+
+func (r *recordingSession) SendUnmute(nick string) error {
+	return r.record(action{Type: "UNMUTE", Nick: nick})
+}
+
+func (r *recordingSession) SendBan(nick string, reason string, duration time.Duration, banip bool) error {
+	return r.record(action{Type: "BAN", Nick: nick, Message: reason, Duration: duration})
+}
+
+func (r *recordingSession) SendUnban(nick string) error {
+	return r.record(action{Type: "UNBAN", Nick: nick})
+}
+
+func (r *recordingSession) GetUsers() []dggchat.User {
+	r.Lock()
+	defer r.Unlock()
+	return append([]dggchat.User{}, r.users...)
+}
+
+// addUser adds or updates a user in the user list.
+func (r *recordingSession) addUser(u dggchat.User) {
+	r.Lock()
+	defer r.Unlock()
+	for i := range r.users {
+		if r.users[i].Nick == u.Nick {
+			r.users[i] = u
+			return
+		}
+	}
+	r.users = append(r.users, u)
+}
+
+// take returns and forgets all recorded actions.
+func (r *recordingSession) take() []action {
+	r.Lock()
+	defer r.Unlock()
+	a := r.actions
+	r.actions = nil
+	return a
+}
+
+// find returns all recorded actions of the given type, e.g. "MUTE".
+func (r *recordingSession) find(actionType string) []action {
+	r.Lock()
+	defer r.Unlock()
+	found := []action{}
+	for _, a := range r.actions {
+		if strings.EqualFold(a.Type, actionType) {
+			found = append(found, a)
+		}
+	}
+	return found
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/MemeLabs/dggchat"
+)
+
+const stateSaveInterval = 15 * time.Second
+
+// errNoState is returned by a stateStore if nothing was saved under a key yet.
+var errNoState = errors.New("no saved state")
+
+// stateStore persists moderation state across restarts.
+type stateStore interface {
+	load(key string, v interface{}) error
+	save(key string, v interface{}) error
+}
+
+// fileStore saves each key as a JSON file in a directory.
+type fileStore struct {
+	dir string
+}
+
+func newFileStore(dir string) (*fileStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &fileStore{dir: dir}, nil
+}
+
+func (f *fileStore) path(key string) string {
+	return filepath.Join(f.dir, key+".json")
+}
+
+func (f *fileStore) load(key string, v interface{}) error {
+	b, err := ioutil.ReadFile(f.path(key))
+	if os.IsNotExist(err) {
+		return errNoState
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// save writes to a temporary file first, so a crash never leaves a partial file.
+func (f *fileStore) save(key string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	tmp := f.path(key) + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, f.path(key))
+}
+
+// nukeState is the persisted nuke history.
+type nukeState struct {
+	NextID int          `json:"next_id"`
+	Nukes  []*nukeEntry `json:"nukes"`
+}
+
+// saveState persists the bot's moderation state, if a store is configured.
+func (b *bot) saveState() error {
+	if b.store == nil {
+		return nil
+	}
+
+	b.mu.Lock()
+	b.pruneNukes()
+	b.pruneOffences()
+	offences := map[string][]offence{}
+	for nick, o := range b.offences {
+		offences[nick] = append([]offence{}, o...)
+	}
+	nukes := nukeState{
+		NextID: b.nextNukeID,
+		Nukes:  append([]*nukeEntry{}, b.nukes...),
+	}
+	messages := []dggchat.Message{}
+	for _, m := range b.log {
+		// the log is preallocated, skip empty entries.
+		if m.Sender.Nick != "" {
+			messages = append(messages, m)
+		}
+	}
+	b.mu.Unlock()
+
+	if err := b.store.save("nukes", nukes); err != nil {
+		return err
+	}
+	if err := b.store.save("offences", offences); err != nil {
+		return err
+	}
+	return b.store.save("log", messages)
+}
+
+// loadState restores the state saved by saveState.
+func (b *bot) loadState() error {
+	if b.store == nil {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var nukes nukeState
+	err := b.store.load("nukes", &nukes)
+	if err != nil && err != errNoState {
+		return err
+	}
+	for _, n := range nukes.Nukes {
+		if err := n.compile(); err != nil {
+			eventLog.warnf("state: dropping nuke #%d: %s\n", n.ID, err.Error())
+			continue
+		}
+		b.nukes = append(b.nukes, n)
+	}
+	b.nextNukeID = nukes.NextID
+	b.pruneNukes()
+
+	offences := map[string][]offence{}
+	err = b.store.load("offences", &offences)
+	if err != nil && err != errNoState {
+		return err
+	}
+	b.offences = offences
+	b.pruneOffences()
+
+	var messages []dggchat.Message
+	err = b.store.load("log", &messages)
+	if err != nil && err != errNoState {
+		return err
+	}
+	b.log = append(b.log, messages...)
+	if len(b.log) > b.maxLogLines {
+		b.log = b.log[len(b.log)-b.maxLogLines:]
+	}
+
+	eventLog.infof("state: restored %d nukes, offences of %d users and %d messages\n",
+		len(b.nukes), len(b.offences), len(messages))
+	return nil
+}
+
+// persistState periodically saves the state, run it in its own goroutine.
+func (b *bot) persistState() {
+	for range time.Tick(stateSaveInterval) {
+		if err := b.saveState(); err != nil {
+			eventLog.errorf("state: save failed: %s\n", err.Error())
+		}
+	}
+}
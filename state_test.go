@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/MemeLabs/dggchat"
+)
+
+func TestStateRoundtrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "modbot-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := newFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := newBot(5)
+	b.store = store
+	b.log = append(b.log[1:], dggchat.Message{
+		Sender:    dggchat.User{Nick: "a"},
+		Message:   "hello",
+		Timestamp: time.Now(),
+	})
+	b.addNukeEntry(&nukeEntry{Pattern: "b.d", Regex: true, Time: time.Now(), Duration: duration{time.Hour}})
+	b.addNukeEntry(&nukeEntry{Pattern: "gone", Time: time.Now().Add(-time.Hour), Duration: duration{time.Minute}})
+	if err := b.saveState(); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := newBot(5)
+	restored.store = store
+	if err := restored.loadState(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(restored.nukes) != 1 || restored.nextNukeID != 2 {
+		t.Fatalf("expected one active nuke and next id 2, got %v, %d", restored.nukes, restored.nextNukeID)
+	}
+	if !restored.nukes[0].match("bad") {
+		t.Error("restored nuke should match 'bad'")
+	}
+	if len(restored.log) != 5 || restored.log[4].Message != "hello" {
+		t.Errorf("unexpected restored log %v", restored.log)
+	}
+}
+
+func TestStateMissing(t *testing.T) {
+	dir, err := ioutil.TempDir("", "modbot-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := newFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v []string
+	if err := store.load("nothing", &v); err != errNoState {
+		t.Errorf("expected errNoState, got %v", err)
+	}
+}
+
+func TestStateIntegerDurations(t *testing.T) {
+	dir, err := ioutil.TempDir("", "modbot-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store, err := newFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// written before durations were saved as strings.
+	old := fmt.Sprintf(`{"next_id": 2, "nukes": [{"id": 1, "pattern": "bad", "time": %q, "duration": %d}]}`,
+		time.Now().Format(time.RFC3339Nano), int64(time.Hour))
+	if err := ioutil.WriteFile(store.path("nukes"), []byte(old), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	b := newBot(5)
+	b.store = store
+	if err := b.loadState(); err != nil {
+		t.Fatal(err)
+	}
+	if len(b.nukes) != 1 || b.nukes[0].Duration.Duration != time.Hour {
+		t.Errorf("expected the nuke with its integer duration, got %v", b.nukes)
+	}
+}